@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+var batchParallel int
+
+// BatchCmd runs every job from a job file
+var BatchCmd = &cobra.Command{
+	Use:   "batch <jobs.yaml>",
+	Short: "Run many tag queries from a job file",
+	Long: `Run a list of download jobs from a YAML (or JSON/TOML) job file.
+
+Every job shares one HTTP client and rate limiter. Unset file type switches
+fall back to the current configuration. With --archive every job gets its own
+archive named after its output directory.
+
+Example job file:
+  parallel: 2
+  jobs:
+    - name: hutao
+      tags: "hu_tao_(genshin_impact)"
+      quantity: 50
+      output: ./downloads/hutao
+      videos: false
+      source: api
+      filters:
+        min_score: 10
+        ratings: [q, e]
+        blacklist: [ai_generated]`,
+	Args: cobra.ExactArgs(1),
+	Run:  runBatch,
+}
+
+func init() {
+	BatchCmd.Flags().IntVarP(&batchParallel, "parallel", "p", 0, "Number of jobs to run at once (overrides the job file)")
+	addArchiveFlags(BatchCmd.Flags())
+
+	RootCmd.AddCommand(BatchCmd)
+}
+
+func runBatch(cmd *cobra.Command, args []string) {
+	checkArchiveSettings()
+
+	jobs, parallel, err := loadJobFile(args[0])
+	if err != nil {
+		log.Fatalf("Failed to load job file: %v", err)
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("No jobs found in the job file.")
+		return
+	}
+
+	if cmd.Flag("parallel").Changed {
+		parallel = batchParallel
+	}
+	if parallel < 1 {
+		parallel = config.AppSettings.Workers
+	}
+
+	fmt.Printf("Running %d jobs (%d at a time)\n\n", len(jobs), parallel)
+
+	start := time.Now()
+	runner := services.NewJobRunner(services.NewClient())
+	if notifier := newNotifier(); notifier != nil {
+		runner.SetNotifier(notifier, warnHookError)
+	}
+	results := runner.RunAll(jobs, parallel, func(result models.JobResult) {
+		if result.Err != nil {
+			fmt.Printf("✗ %s: %v\n", result.Job.DisplayName(), result.Err)
+			return
+		}
+		fmt.Printf("✓ %s: %d downloaded in %s\n", result.Job.DisplayName(), result.Stats.Downloaded, utils.FormatDuration(result.Duration))
+		if len(result.Stats.Archives) > 0 {
+			fmt.Printf("  archived to %s\n", describeArchives(result.Stats.Archives))
+		}
+	})
+
+	printBatchSummary(results, time.Since(start))
+
+	for _, result := range results {
+		if result.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+func loadJobFile(path string) ([]models.Job, int, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []models.Job
+	if err := v.UnmarshalKey("jobs", &jobs); err != nil {
+		return nil, 0, fmt.Errorf("invalid jobs list: %w", err)
+	}
+
+	return jobs, v.GetInt("parallel"), nil
+}
+
+func printBatchSummary(results []models.JobResult, elapsed time.Duration) {
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("Batch Summary:")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tDOWNLOADED\tSKIPPED\tFAILED\tIMAGES\tGIFS\tVIDEOS\tTIME\tSTATUS")
+
+	var total models.DownloadStats
+	for _, result := range results {
+		stats := result.Stats
+		if stats == nil {
+			stats = &models.DownloadStats{}
+		}
+
+		status := "ok"
+		if result.Err != nil {
+			status = "error"
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			utils.TruncateString(result.Job.DisplayName(), 40),
+			stats.Downloaded, stats.Skipped, stats.Failed,
+			stats.Images, stats.Gifs, stats.Videos,
+			utils.FormatDuration(result.Duration), status)
+
+		total.Downloaded += stats.Downloaded
+		total.Skipped += stats.Skipped
+		total.Failed += stats.Failed
+		total.Images += stats.Images
+		total.Gifs += stats.Gifs
+		total.Videos += stats.Videos
+	}
+
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n",
+		total.Downloaded, total.Skipped, total.Failed,
+		total.Images, total.Gifs, total.Videos,
+		utils.FormatDuration(elapsed))
+	w.Flush()
+}
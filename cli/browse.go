@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/models"
+	"r34-go/services"
+	"r34-go/tui"
+)
+
+// BrowseCmd pages through search results to pick posts by hand
+var BrowseCmd = &cobra.Command{
+	Use:   "browse",
+	Short: "Browse search results and pick posts to download",
+	Long: `Page through search results in a full-screen terminal view, mark posts and
+download only the marked ones. The filters of the config file and the filter
+flags apply to the results and the downloads.
+
+Keys:
+  up/down, j/k       Move between posts
+  left/right, p/n    Previous or next page
+  space              Mark or unmark the post
+  a                  Mark or unmark every post on the page
+  o, enter           Open the post page in the browser
+  v                  Toggle the image preview
+  d                  Download the marked posts
+  q, esc             Quit without downloading
+
+Previews need a terminal with inline images. Kitty, WezTerm and Ghostty are
+detected automatically; use --preview sixel for terminals supporting Sixel.
+
+Example:
+  r34-go browse -t "hu_tao_(genshin_impact) score>50"`,
+	Run: runBrowse,
+}
+
+var (
+	browsePageSize int
+	browsePreview  string
+)
+
+func init() {
+	BrowseCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags or tag query to search for (required)")
+	BrowseCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory for marked posts")
+	bindSetting(BrowseCmd.Flags(), "output", "output")
+	BrowseCmd.Flags().IntVar(&browsePageSize, "page-size", 50, "Number of posts per page (at most 100)")
+	BrowseCmd.Flags().StringVar(&browsePreview, "preview", "auto", "Image preview: auto, kitty, sixel or none")
+
+	flags := BrowseCmd.Flags()
+	flags.IntVar(&minScore, "min-score", 0, "Skip posts with a lower score")
+	flags.StringSliceVar(&ratings, "rating", nil, "Only keep posts with these ratings, e.g. s,q,e")
+	flags.StringSliceVar(&blacklist, "blacklist", nil, "Skip posts with any of these tags")
+	flags.StringSliceVar(&artists, "artist", nil, "Only keep posts by one of these artists")
+	flags.StringSliceVar(&characters, "character", nil, "Only keep posts with one of these characters")
+	flags.StringSliceVar(&copyrights, "copyright", nil, "Only keep posts from one of these copyrights")
+	bindSetting(flags, "min-score", "filters.min_score")
+	bindSetting(flags, "rating", "filters.ratings")
+	bindSetting(flags, "blacklist", "filters.blacklist")
+	bindSetting(flags, "artist", "filters.artists")
+	bindSetting(flags, "character", "filters.characters")
+	bindSetting(flags, "copyright", "filters.copyrights")
+
+	BrowseCmd.MarkFlagRequired("tags")
+	BrowseCmd.RegisterFlagCompletionFunc("tags", completeQuery)
+
+	RootCmd.AddCommand(BrowseCmd)
+}
+
+func runBrowse(cmd *cobra.Command, args []string) {
+	if browsePageSize < 1 || browsePageSize > 100 {
+		log.Fatalf("Error: --page-size must be between 1 and 100")
+	}
+
+	preview, err := tui.ParsePreviewMode(browsePreview)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	plan := compileQuery(tags)
+	services.RecordQuery(tags)
+
+	options := downloadOptions()
+	options.Query = plan.Filter
+	apiService := services.NewAPIService()
+	apiService.SetOptions(options)
+
+	// Posts failing the filters or the local part of the query are left
+	// out, so a page may show fewer posts than the page size
+	source := func(page int) ([]models.Post, bool, error) {
+		posts, err := apiService.GetPosts(plan.Tags, page, browsePageSize)
+		if err != nil {
+			return nil, false, err
+		}
+		end := len(posts) < browsePageSize
+		return apiService.Matching(posts), end, nil
+	}
+
+	browser := tui.NewBrowser(tags, source, services.NewClient(), preview)
+	posts, err := browser.Run()
+	if err != nil {
+		log.Fatalf("Browse failed: %v", err)
+	}
+
+	if len(posts) == 0 {
+		fmt.Println("No posts marked for download.")
+		return
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	fmt.Printf("Downloading %d marked posts\n", len(posts))
+	fmt.Printf("Output directory: %s\n", outputDir)
+	fmt.Println()
+
+	bar := newProgressBar(len(posts), "Downloading...")
+	stats, err := apiService.DownloadPosts(posts, outputDir, func(current, total int) {
+		bar.Set(current)
+	})
+	bar.Finish()
+	if err != nil {
+		log.Fatalf("Download failed: %v", err)
+	}
+
+	printDownloadSummary(stats, outputDir)
+}
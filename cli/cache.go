@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// CacheCmd shows the response cache
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Show the response cache",
+	Long: `Show the on-disk cache of API list responses and HTML pages. Media files are
+never cached.
+
+The cache is off by default. Turn it on with:
+  r34-go config set cache.enabled true
+
+Then --no-cache skips it for one run and --refresh fetches every page again,
+updating the cache. watch and daemon never use it, as a cached page would
+hide new posts from them.`,
+	Args: cobra.NoArgs,
+	Run:  runCacheInfo,
+}
+
+// CacheClearCmd empties the response cache
+var CacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached response",
+	Args:  cobra.NoArgs,
+	Run:   runCacheClear,
+}
+
+var (
+	noCache      bool
+	refreshCache bool
+)
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Don't use the response cache")
+	RootCmd.PersistentFlags().BoolVar(&refreshCache, "refresh", false, "Fetch cached pages again and update the cache")
+	bindInvertedSetting(RootCmd.PersistentFlags(), "no-cache", "cache.enabled")
+
+	CacheCmd.AddCommand(CacheClearCmd)
+	RootCmd.AddCommand(CacheCmd)
+}
+
+// responseCacheDir is where responses are cached
+func responseCacheDir() string {
+	return filepath.Join(config.CacheDir(), "responses")
+}
+
+// newResponseCache creates the response cache from the settings
+func newResponseCache() *services.ResponseCache {
+	settings := config.AppSettings.Cache
+	return services.NewResponseCache(responseCacheDir(), settings.TTL, int64(settings.MaxSizeMB)<<20, refreshCache)
+}
+
+// setupResponseCache makes the clients of this run use the response cache
+// when it is enabled. Commands that poll for new posts never use it.
+func setupResponseCache(cmd *cobra.Command) {
+	if cmd == WatchCmd || cmd == DaemonCmd {
+		return
+	}
+	if config.AppSettings.Cache.Enabled {
+		services.UseResponseCache(newResponseCache())
+	}
+}
+
+func runCacheInfo(cmd *cobra.Command, args []string) {
+	settings := config.AppSettings.Cache
+	state := "off"
+	if settings.Enabled {
+		state = "on"
+	}
+
+	info := newResponseCache().Info()
+	fmt.Printf("Response cache: %s\n", state)
+	fmt.Printf("Directory: %s\n", responseCacheDir())
+	fmt.Printf("Entries: %d (%d expired)\n", info.Entries, info.Expired)
+	fmt.Printf("Size: %s of %d MB\n", utils.FormatFileSize(info.Size), settings.MaxSizeMB)
+	fmt.Printf("TTL: %s\n", settings.TTL)
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) {
+	cache := newResponseCache()
+	info := cache.Info()
+	if err := cache.Clear(); err != nil {
+		log.Fatalf("Error: Failed to clear the cache: %v", err)
+	}
+	fmt.Printf("Removed %d cached responses (%s).\n", info.Entries, utils.FormatFileSize(info.Size))
+}
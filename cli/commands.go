@@ -5,12 +5,15 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 
 	"r34-go/config"
+	"r34-go/events"
 	"r34-go/models"
+	"r34-go/query"
 	"r34-go/services"
 )
 
@@ -22,6 +25,22 @@ var (
 	images    bool
 	gifs      bool
 	videos    bool
+	minScore  int
+	ratings   []string
+	blacklist []string
+	storeDir  string
+	linkMode  string
+
+	phash         bool
+	phashDistance int
+	phashAction   string
+	updateViews   bool
+
+	filenameTemplate string
+	sidecar          bool
+	artists          []string
+	characters       []string
+	copyrights       []string
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -38,16 +57,15 @@ Examples:
   r34-go -t "animated" -q 20 --videos --no-images --no-gifs --api
 
   # Download everything (images, gifs, videos) using HTML parsing
-  r34-go -t "furina_(genshin_impact)" -q 100 --no-api`,
-	Run: runDownload,
-}
+  r34-go -t "furina_(genshin_impact)" -q 100 --no-api
+
+  # Combine tags with | (or), & (and), ! (not) and field comparisons
+  r34-go -t "(furina_(genshin_impact) | hu_tao_(genshin_impact)) & !comic & score>50 & rating:s"
 
-// ConfigCmd shows current configuration
-var ConfigCmd = &cobra.Command{
-	Use:   "config",
-	Short: "Show current configuration",
-	Long:  `Display the current configuration settings`,
-	Run:   showConfig,
+  # Use the settings of a profile from config.yaml
+  r34-go --profile work -t "animated"`,
+	PersistentPreRun: applyConfigLayers,
+	Run:              runDownload,
 }
 
 // CheckCmd checks if content exists for given tags
@@ -61,9 +79,17 @@ var CheckCmd = &cobra.Command{
 func init() {
 	// Initialize configuration
 	config.Init()
+	if moved, err := config.Migrated(); len(moved) > 0 || err != nil {
+		for _, move := range moved {
+			fmt.Fprintf(os.Stderr, "Moved %s\n", move)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
 
 	// Root command flags
-	RootCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags to search for (required)")
+	RootCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags or tag query to search for (required)")
 	RootCmd.Flags().Uint16VarP(&quantity, "quantity", "q", 100, "Number of items to download")
 	RootCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory")
 	RootCmd.Flags().BoolVarP(&useAPI, "api", "a", config.AppSettings.IsAPI, "Use API method (faster) instead of HTML parsing")
@@ -76,17 +102,77 @@ func init() {
 	RootCmd.Flags().BoolVar(&gifs, "no-gifs", !config.AppSettings.Gif, "Don't download GIFs")
 	RootCmd.Flags().BoolVar(&videos, "no-videos", !config.AppSettings.Video, "Don't download videos")
 
+	// Client-side filters
+	RootCmd.Flags().IntVar(&minScore, "min-score", 0, "Skip posts with a lower score")
+	RootCmd.Flags().StringSliceVar(&ratings, "rating", nil, "Only keep posts with these ratings, e.g. s,q,e")
+	RootCmd.Flags().StringSliceVar(&blacklist, "blacklist", nil, "Skip posts with any of these tags")
+
+	// Tag category filters
+	RootCmd.Flags().StringSliceVar(&artists, "artist", nil, "Only keep posts by one of these artists (API only)")
+	RootCmd.Flags().StringSliceVar(&characters, "character", nil, "Only keep posts with one of these characters (API only)")
+	RootCmd.Flags().StringSliceVar(&copyrights, "copyright", nil, "Only keep posts from one of these copyrights (API only)")
+
+	// File naming and metadata
+	RootCmd.Flags().StringVar(&filenameTemplate, "filename-template", config.AppSettings.FilenameTemplate, "File name template, e.g. \"{artist}_{id}\" (API only)")
+	RootCmd.Flags().BoolVar(&sidecar, "sidecar", config.AppSettings.Sidecar, "Write a <file>.json with the post metadata next to each file")
+
+	// Content store
+	RootCmd.Flags().StringVar(&storeDir, "store", config.AppSettings.StoreDir, "Shared content store directory, output files become links into it")
+	RootCmd.Flags().StringVar(&linkMode, "link-mode", config.AppSettings.LinkMode, "How to link files from the store: auto, hardlink, symlink or reflink")
+
+	// Near-duplicate detection
+	RootCmd.Flags().BoolVar(&phash, "phash", config.AppSettings.PHash, "Detect near-duplicate images using perceptual hashes")
+	RootCmd.Flags().IntVar(&phashDistance, "phash-distance", config.AppSettings.PHashDistance, "Maximum hash distance (0-64) for images to count as near-duplicates")
+	RootCmd.Flags().StringVar(&phashAction, "phash-action", config.AppSettings.PHashAction, "What to do with near-duplicates: flag or skip")
+
+	RootCmd.Flags().BoolVar(&updateViews, "update-views", config.AppSettings.Views.Auto, "Update the tag-based symlink views after downloading")
+	// Archives
+	addArchiveFlags(RootCmd.Flags())
+
+	RootCmd.Flags().StringVar(&progressMode, "progress", "bar", "How to report progress: bar, json (events as JSON lines on stdout) or none")
+
+	// Flags overriding settings
+	bindSetting(RootCmd.Flags(), "quantity", "limit")
+	bindSetting(RootCmd.Flags(), "output", "output")
+	bindSetting(RootCmd.Flags(), "api", "is_api")
+	bindSetting(RootCmd.Flags(), "images", "images")
+	bindSetting(RootCmd.Flags(), "gifs", "gif")
+	bindSetting(RootCmd.Flags(), "videos", "video")
+	bindInvertedSetting(RootCmd.Flags(), "no-images", "images")
+	bindInvertedSetting(RootCmd.Flags(), "no-gifs", "gif")
+	bindInvertedSetting(RootCmd.Flags(), "no-videos", "video")
+	bindSetting(RootCmd.Flags(), "min-score", "filters.min_score")
+	bindSetting(RootCmd.Flags(), "rating", "filters.ratings")
+	bindSetting(RootCmd.Flags(), "blacklist", "filters.blacklist")
+	bindSetting(RootCmd.Flags(), "artist", "filters.artists")
+	bindSetting(RootCmd.Flags(), "character", "filters.characters")
+	bindSetting(RootCmd.Flags(), "copyright", "filters.copyrights")
+	bindSetting(RootCmd.Flags(), "filename-template", "filename_template")
+	bindSetting(RootCmd.Flags(), "sidecar", "sidecar")
+	bindSetting(RootCmd.Flags(), "store", "store_dir")
+	bindSetting(RootCmd.Flags(), "link-mode", "link_mode")
+	bindSetting(RootCmd.Flags(), "phash", "phash")
+	bindSetting(RootCmd.Flags(), "phash-distance", "phash_distance")
+	bindSetting(RootCmd.Flags(), "phash-action", "phash_action")
+	bindSetting(RootCmd.Flags(), "update-views", "views.auto")
+
 	// Mark required flags
 	RootCmd.MarkFlagRequired("tags")
+	RootCmd.RegisterFlagCompletionFunc("tags", completeQuery)
+	RootCmd.RegisterFlagCompletionFunc("blacklist", completeTags)
+	RootCmd.RegisterFlagCompletionFunc("artist", completeTagsOfType(models.TagArtist))
+	RootCmd.RegisterFlagCompletionFunc("character", completeTagsOfType(models.TagCharacter))
+	RootCmd.RegisterFlagCompletionFunc("copyright", completeTagsOfType(models.TagCopyright))
 
 	// Add subcommands
-	RootCmd.AddCommand(ConfigCmd)
 	RootCmd.AddCommand(CheckCmd)
 
 	// Check command flags
-	CheckCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags to search for (required)")
+	CheckCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags or tag query to search for (required)")
 	CheckCmd.Flags().BoolVarP(&useAPI, "api", "a", config.AppSettings.IsAPI, "Use API method to check")
 	CheckCmd.MarkFlagRequired("tags")
+	bindSetting(CheckCmd.Flags(), "api", "is_api")
+	CheckCmd.RegisterFlagCompletionFunc("tags", completeQuery)
 }
 
 func runDownload(cmd *cobra.Command, args []string) {
@@ -107,6 +193,20 @@ func runDownload(cmd *cobra.Command, args []string) {
 	config.AppSettings.Gif = gifs
 	config.AppSettings.Video = videos
 	config.AppSettings.IsAPI = useAPI
+	config.AppSettings.PHash = phash
+	config.AppSettings.PHashDistance = phashDistance
+	config.AppSettings.PHashAction = phashAction
+	config.AppSettings.Views.Auto = updateViews
+	config.AppSettings.FilenameTemplate = filenameTemplate
+	config.AppSettings.Sidecar = sidecar
+	applyStoreFlags(cmd)
+
+	checkArchiveSettings()
+
+	if phashAction != "flag" && phashAction != "skip" {
+		log.Fatalf("Error: Invalid --phash-action %q (expected flag or skip)", phashAction)
+	}
+	checkFilenameTemplate(filenameTemplate)
 
 	// Validate that at least one file type is enabled
 	if !images && !gifs && !videos {
@@ -118,25 +218,31 @@ func runDownload(cmd *cobra.Command, args []string) {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
-	fmt.Printf("Downloading %d items for tags: %s\n", quantity, tags)
-	fmt.Printf("Output directory: %s\n", outputDir)
-	fmt.Printf("Method: %s\n", getMethodName())
-	fmt.Printf("File types: %s\n", getEnabledFileTypes())
-	fmt.Println()
-
-	// Create progress bar
-	bar := progressbar.NewOptions(int(quantity),
-		progressbar.OptionSetDescription("Downloading..."),
-		progressbar.OptionSetWidth(50),
-		progressbar.OptionShowCount(),
-		progressbar.OptionShowIts(),
-		progressbar.OptionSetRenderBlankState(true),
-	)
-
-	// Progress callback function
-	progressCallback := func(current, total int) {
-		bar.Set(current)
+	plan := compileQuery(tags)
+	services.RecordQuery(tags)
+	options := downloadOptions()
+	options.Query = plan.Filter
+	info := infoOutput()
+
+	fmt.Fprintf(info, "Downloading %d items for tags: %s\n", quantity, tags)
+	fmt.Fprintf(info, "Output directory: %s\n", outputDir)
+	fmt.Fprintf(info, "Method: %s\n", getMethodName())
+	fmt.Fprintf(info, "File types: %s\n", getEnabledFileTypes())
+	fmt.Fprintln(info)
+
+	// Report progress through the selected sink
+	sink, finishProgress := newProgressSink(int(quantity), "Downloading...")
+	options.Events = sink
+
+	// Collect the new files for the notification hooks
+	notifier := newNotifier()
+	files := &events.FileList{}
+	if notifier != nil && sink != nil {
+		options.Events = events.Multi{sink, files}
+	} else if notifier != nil {
+		options.Events = files
 	}
+	started := time.Now()
 
 	var stats *models.DownloadStats
 	var err error
@@ -144,71 +250,81 @@ func runDownload(cmd *cobra.Command, args []string) {
 	if useAPI {
 		// Use API method
 		apiService := services.NewAPIService()
+		apiService.SetOptions(options)
 
 		// Check if content exists
-		count, err := apiService.GetContentCount(tags)
-		if err != nil {
-			log.Fatalf("Failed to get content count: %v", err)
+		count, countErr := apiService.GetContentCount(plan.Tags)
+		if countErr != nil {
+			log.Fatalf("Failed to get content count: %v", countErr)
 		}
 
 		if count == 0 {
-			fmt.Println("No content found for the specified tags.")
+			fmt.Fprintln(info, "No content found for the specified tags.")
 			return
 		}
 
-		fmt.Printf("Found %d total items available.\n", count)
+		fmt.Fprintf(info, "Found %d total items available.\n", count)
 
 		if quantity > uint16(count) {
-			fmt.Printf("Warning: Requested %d items but only %d available. Downloading all available items.\n", quantity, count)
+			fmt.Fprintf(info, "Warning: Requested %d items but only %d available. Downloading all available items.\n", quantity, count)
 			quantity = uint16(count)
 		}
 
-		stats, err = apiService.DownloadContent(outputDir, tags, quantity, progressCallback)
+		stats, err = apiService.DownloadContent(outputDir, plan.Tags, quantity, nil)
 	} else {
 		// Use HTML parsing method
 		htmlService := services.NewHTMLService()
+		htmlService.SetOptions(options)
+		if options.Filter.UsesCategories() {
+			fmt.Fprintln(info, "Warning: without the API, artist, character and copyright filters match any tag.")
+		}
 
 		// Check if content exists
-		found, err := htmlService.IsSomethingFound(tags)
-		if err != nil {
-			log.Fatalf("Failed to check for content: %v", err)
+		found, foundErr := htmlService.IsSomethingFound(plan.Tags)
+		if foundErr != nil {
+			log.Fatalf("Failed to check for content: %v", foundErr)
 		}
 
 		if !found {
-			fmt.Println("No content found for the specified tags.")
+			fmt.Fprintln(info, "No content found for the specified tags.")
 			return
 		}
 
-		stats, err = htmlService.DownloadContent(outputDir, tags, quantity, progressCallback)
+		stats, err = htmlService.DownloadContent(outputDir, plan.Tags, quantity, nil)
+	}
+
+	if notifier != nil {
+		job := models.Job{Tags: tags, Output: outputDir}
+		if hookErr := notifier.Notify(services.NewJobReport(job, stats, err, files.Files(), started)); hookErr != nil {
+			warnHookError(hookErr)
+		}
 	}
 
 	if err != nil {
 		log.Fatalf("Download failed: %v", err)
 	}
 
-	// Finish progress bar
-	bar.Finish()
+	finishProgress()
 
-	// Print final statistics
-	printDownloadSummary(stats, outputDir)
-}
-
-func showConfig(cmd *cobra.Command, args []string) {
-	fmt.Println("Current Configuration:")
-	fmt.Printf("  Limit: %d\n", config.AppSettings.Limit)
-	fmt.Printf("  Download Images: %t\n", config.AppSettings.Images)
-	fmt.Printf("  Download GIFs: %t\n", config.AppSettings.Gif)
-	fmt.Printf("  Download Videos: %t\n", config.AppSettings.Video)
-	fmt.Printf("  Use API: %t\n", config.AppSettings.IsAPI)
+	// Print final statistics, json mode already ends with a run_finished event
+	if progressMode != "json" {
+		printDownloadSummary(stats, outputDir)
+	}
 }
 
 func checkContent(cmd *cobra.Command, args []string) {
+	plan := compileQuery(tags)
+	services.RecordQuery(tags)
+
 	fmt.Printf("Checking content for tags: %s\n", tags)
 	fmt.Printf("Method: %s\n", getMethodName())
+	if plan.Filter != nil {
+		fmt.Printf("Site search: %s (the rest is checked per post, so counts are upper bounds)\n", plan.Tags)
+	}
 
 	if useAPI {
 		apiService := services.NewAPIService()
-		count, err := apiService.GetContentCount(tags)
+		count, err := apiService.GetContentCount(plan.Tags)
 		if err != nil {
 			log.Fatalf("Failed to check content: %v", err)
 		}
@@ -217,17 +333,18 @@ func checkContent(cmd *cobra.Command, args []string) {
 			fmt.Printf("✓ Found %d items available\n", count)
 		} else {
 			fmt.Println("✗ No content found for the specified tags")
+			printSuggestions(plan.Tags)
 		}
 	} else {
 		htmlService := services.NewHTMLService()
-		found, err := htmlService.IsSomethingFound(tags)
+		found, err := htmlService.IsSomethingFound(plan.Tags)
 		if err != nil {
 			log.Fatalf("Failed to check content: %v", err)
 		}
 
 		if found {
 			// Try to get more detailed info
-			maxPid, err := htmlService.GetMaxPid(tags)
+			maxPid, err := htmlService.GetMaxPid(plan.Tags)
 			if err == nil && maxPid > 0 {
 				fmt.Printf("✓ Content found (up to page %d)\n", maxPid)
 			} else {
@@ -235,10 +352,51 @@ func checkContent(cmd *cobra.Command, args []string) {
 			}
 		} else {
 			fmt.Println("✗ No content found for the specified tags")
+			printSuggestions(plan.Tags)
 		}
 	}
 }
 
+// applyStoreFlags copies the content store flags into the settings when given
+func applyStoreFlags(cmd *cobra.Command) {
+	if cmd.Flag("store").Changed {
+		config.AppSettings.StoreDir = storeDir
+	}
+	if cmd.Flag("link-mode").Changed {
+		config.AppSettings.LinkMode = linkMode
+	}
+}
+
+// checkFilenameTemplate stops on a template that gives posts the same name
+func checkFilenameTemplate(template string) {
+	if err := services.ValidateFilenameTemplate(template); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// compileQuery splits the tag query into a site search and a local filter
+func compileQuery(input string) *query.Plan {
+	plan, err := query.Compile(input)
+	if err != nil {
+		log.Fatalf("Error: Invalid tag query: %v", err)
+	}
+	return plan
+}
+
+func downloadOptions() services.DownloadOptions {
+	options := services.OptionsFromSettings()
+	options.Filter = models.Filter{
+		MinScore:  minScore,
+		Ratings:   ratings,
+		Blacklist: blacklist,
+
+		Artists:    artists,
+		Characters: characters,
+		Copyrights: copyrights,
+	}
+	return options
+}
+
 func getMethodName() string {
 	if useAPI {
 		return "API (faster)"
@@ -265,7 +423,45 @@ func getEnabledFileTypes() string {
 	return strings.Join(types, ", ")
 }
 
+func newProgressBar(total int, description string) *progressbar.ProgressBar {
+	return progressbar.NewOptions(total,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetRenderBlankState(true),
+	)
+}
+
 func printDownloadSummary(stats *models.DownloadStats, outputDir string) {
+	printDownloadStats(stats)
+
+	if len(stats.Archives) > 0 {
+		fmt.Println("\nArchives:")
+		for _, archive := range stats.Archives {
+			fmt.Printf("  %s\n", archive)
+		}
+		if !config.AppSettings.Archive.KeepFiles {
+			return
+		}
+	}
+
+	fmt.Printf("\nFiles saved to: %s\n", outputDir)
+
+	// Show folder structure
+	fmt.Println("\nFolder structure:")
+	if config.AppSettings.Images {
+		fmt.Printf("  %s/Images/\n", outputDir)
+	}
+	if config.AppSettings.Gif {
+		fmt.Printf("  %s/Gif/\n", outputDir)
+	}
+	if config.AppSettings.Video {
+		fmt.Printf("  %s/Video/\n", outputDir)
+	}
+}
+
+func printDownloadStats(stats *models.DownloadStats) {
 	fmt.Println("\n" + strings.Repeat("=", 50))
 	fmt.Println("Download Summary:")
 	fmt.Printf("Total requested: %d\n", stats.Total)
@@ -277,6 +473,9 @@ func printDownloadSummary(stats *models.DownloadStats, outputDir string) {
 	if stats.Skipped > 0 {
 		fmt.Printf("Skipped (already exists): %d\n", stats.Skipped)
 	}
+	if stats.NearDuplicates > 0 {
+		fmt.Printf("Near-duplicate images: %d (review with \"r34-go dupes\")\n", stats.NearDuplicates)
+	}
 
 	if stats.Images > 0 || stats.Gifs > 0 || stats.Videos > 0 {
 		fmt.Println("\nBy file type:")
@@ -290,18 +489,4 @@ func printDownloadSummary(stats *models.DownloadStats, outputDir string) {
 			fmt.Printf("  Videos: %d\n", stats.Videos)
 		}
 	}
-
-	fmt.Printf("\nFiles saved to: %s\n", outputDir)
-
-	// Show folder structure
-	fmt.Println("\nFolder structure:")
-	if config.AppSettings.Images {
-		fmt.Printf("  %s/Images/\n", outputDir)
-	}
-	if config.AppSettings.Gif {
-		fmt.Printf("  %s/Gif/\n", outputDir)
-	}
-	if config.AppSettings.Video {
-		fmt.Printf("  %s/Video/\n", outputDir)
-	}
 }
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/models"
+	"r34-go/services"
+)
+
+// maxCompletions caps the number of tags offered by shell completion
+const maxCompletions = 50
+
+// completeQuery completes the last tag of a tag query from the local tag
+// cache, offering previously used queries first. It never touches the
+// network, so completion stays fast and works offline.
+func completeQuery(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completions := services.LoadQueryHistory().Matching(toComplete)
+	completions = append(completions, tagCompletions(toComplete, nil)...)
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTags completes tag arguments and the last entry of comma-separated
+// tag flags
+func completeTags(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return tagCompletions(toComplete, nil), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTagsOfType returns a completion function offering only tags of one
+// category, e.g. for --artist
+func completeTagsOfType(tagType models.TagType) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return tagCompletions(toComplete, &tagType), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// tagCompletions offers cached tags for the term being typed at the end of
+// value, keeping everything before it
+func tagCompletions(value string, tagType *models.TagType) []string {
+	head, term := splitLastTerm(value)
+
+	var completions []string
+	for _, tag := range services.NewTagService().Cached(term, 0) {
+		if tagType != nil && tag.Type != *tagType {
+			continue
+		}
+		completions = append(completions, fmt.Sprintf("%s%s\t%s, %d posts", head, tag.Name, tag.Type, tag.Count))
+		if len(completions) >= maxCompletions {
+			break
+		}
+	}
+	return completions
+}
+
+// splitLastTerm splits value before the tag being typed. Operators and
+// negation in front of the tag stay in the head.
+func splitLastTerm(value string) (string, string) {
+	i := strings.LastIndexAny(value, " \t|&,")
+	head, term := value[:i+1], value[i+1:]
+
+	for term != "" && strings.ContainsRune("(!-~", rune(term[0])) {
+		head += term[:1]
+		term = term[1:]
+	}
+	return head, term
+}
@@ -0,0 +1,390 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	"r34-go/config"
+)
+
+// ConfigCmd shows current configuration
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show current configuration",
+	Long: `Display the current configuration settings with the layer each value comes
+from: a flag, an R34_* environment variable, the active profile, the config
+file or the default.
+
+The config file is config.yaml in $XDG_CONFIG_HOME/r34-go (usually
+~/.config/r34-go), or the file named by R34_CONFIG. Caches are kept in
+$XDG_CACHE_HOME/r34-go and state such as the job queue in
+$XDG_DATA_HOME/r34-go. Files from ~/.r34downloader are moved there on start.
+
+Use the subcommands to change the config file. Values are checked before they
+are saved.
+
+Examples:
+  r34-go config set limit 50
+  r34-go config set filters.ratings s,q
+  r34-go --profile work config set output ./work
+  r34-go config unset filters.ratings
+  r34-go config edit`,
+	Args: cobra.NoArgs,
+	Run:  showConfig,
+}
+
+// ConfigGetCmd prints a setting
+var ConfigGetCmd = &cobra.Command{
+	Use:               "get <key>",
+	Short:             "Print the effective value of a setting",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConfigKey,
+	Run:               runConfigGet,
+}
+
+// ConfigSetCmd changes a setting in the config file
+var ConfigSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting in the config file",
+	Long: `Change a setting in the config file. List values are separated by commas
+or given as separate arguments. With --profile the value is set in that
+profile; keys may also name a profile directly, as in profiles.work.limit.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeConfigKey,
+	Run:               runConfigSet,
+}
+
+// ConfigUnsetCmd removes a setting from the config file
+var ConfigUnsetCmd = &cobra.Command{
+	Use:               "unset <key>",
+	Short:             "Remove a setting from the config file so it uses its default",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConfigKey,
+	Run:               runConfigUnset,
+}
+
+// ConfigEditCmd opens the config file in an editor
+var ConfigEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open the config file in $EDITOR",
+	Long: `Open a copy of the config file in $VISUAL or $EDITOR. The file is checked
+when the editor exits and only replaces the config file when it is valid.`,
+	Args: cobra.NoArgs,
+	Run:  runConfigEdit,
+}
+
+// ConfigInitCmd writes a config file with every setting
+var ConfigInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a config file with every setting and its default",
+	Args:  cobra.NoArgs,
+	Run:   runConfigInit,
+}
+
+var (
+	configInitPath  string
+	configInitForce bool
+)
+
+func init() {
+	ConfigInitCmd.Flags().StringVar(&configInitPath, "path", "", "Where to write the config file (defaults to the config file in use)")
+	ConfigInitCmd.Flags().BoolVar(&configInitForce, "force", false, "Overwrite an existing config file")
+
+	ConfigCmd.AddCommand(ConfigGetCmd)
+	ConfigCmd.AddCommand(ConfigSetCmd)
+	ConfigCmd.AddCommand(ConfigUnsetCmd)
+	ConfigCmd.AddCommand(ConfigEditCmd)
+	ConfigCmd.AddCommand(ConfigInitCmd)
+	RootCmd.AddCommand(ConfigCmd)
+}
+
+func showConfig(cmd *cobra.Command, args []string) {
+	fmt.Println("Current Configuration:")
+	if profile := config.ActiveProfile(); profile != "" {
+		fmt.Printf("Profile: %s\n", profile)
+	}
+	if file := viper.ConfigFileUsed(); file != "" {
+		fmt.Printf("Config file: %s\n", file)
+	} else {
+		fmt.Printf("Config file: none (r34-go config init writes %s)\n", config.File())
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, key := range config.Keys() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, settingValue(key), config.Source(key))
+	}
+	w.Flush()
+}
+
+// settingValue formats the effective value of a setting for display
+func settingValue(key string) string {
+	value := viper.Get(key)
+	if key == "credentials.api_key" && viper.GetString(key) != "" {
+		return "********"
+	}
+
+	switch v := value.(type) {
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case []any:
+		if len(v) > 0 {
+			if _, ok := v[0].(map[string]any); ok {
+				return fmt.Sprintf("(%d entries)", len(v))
+			}
+		}
+		return "[" + strings.Join(viper.GetStringSlice(key), ", ") + "]"
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	default:
+		if reflect.ValueOf(value).Kind() == reflect.Slice {
+			return fmt.Sprintf("(%d entries)", reflect.ValueOf(value).Len())
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+func runConfigGet(cmd *cobra.Command, args []string) {
+	setting, err := config.Lookup(args[0])
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	switch setting.Kind {
+	case config.KindList:
+		fmt.Println(strings.Join(viper.GetStringSlice(setting.Key), ","))
+	case config.KindObjects:
+		data, err := yaml.Marshal(viper.Get(setting.Key))
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Print(string(data))
+	default:
+		fmt.Println(viper.GetString(setting.Key))
+	}
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) {
+	key, profile := configFileKey(args[0])
+	setting, err := config.Lookup(key)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	value, err := setting.Parse(strings.Join(args[1:], ","))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if setting.Kind != config.KindList && len(args) > 2 {
+		log.Fatalf("Error: %s takes a single value, quote values with spaces", setting.Key)
+	}
+
+	path := config.File()
+	if err := config.SetValue(path, profileKey(profile, setting.Key), value); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	fmt.Printf("Set %s to %v in %s\n", setting.Key, formatConfigValue(value), configTarget(path, profile))
+	warnOverridden(setting.Key, profile)
+}
+
+func runConfigUnset(cmd *cobra.Command, args []string) {
+	key, profile := configFileKey(args[0])
+	setting, err := config.Lookup(key)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	path := config.File()
+	removed, err := config.UnsetValue(path, profileKey(profile, setting.Key))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if !removed {
+		fmt.Printf("%s is not set in %s\n", setting.Key, configTarget(path, profile))
+		return
+	}
+	fmt.Printf("Removed %s from %s\n", setting.Key, configTarget(path, profile))
+}
+
+func runConfigEdit(cmd *cobra.Command, args []string) {
+	path := config.File()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = config.Template()
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	tmp, err := os.CreateTemp("", "r34-config-*.yaml")
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	tmp.Close()
+	if err := os.WriteFile(tmp.Name(), data, 0600); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	editor := editorCommand(tmp.Name())
+	if err := editor.Run(); err != nil {
+		log.Fatalf("Error: Editor failed: %v (your changes are in %s)", err, tmp.Name())
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if string(edited) == string(data) {
+		os.Remove(tmp.Name())
+		fmt.Println("No changes.")
+		return
+	}
+
+	values, err := config.ReadValues(tmp.Name())
+	if err != nil {
+		log.Fatalf("Error: %v (your changes are in %s)", err, tmp.Name())
+	}
+	if errs := config.ValidateValues(values); len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "The config file was not saved, it has %d error(s):\n", len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Your changes are in %s\n", tmp.Name())
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(path, edited, 0644); err != nil {
+		log.Fatalf("Error: Failed to save config file: %v (your changes are in %s)", err, tmp.Name())
+	}
+	os.Remove(tmp.Name())
+	fmt.Printf("Saved %s\n", path)
+}
+
+func runConfigInit(cmd *cobra.Command, args []string) {
+	path := configInitPath
+	if path == "" {
+		path = config.File()
+	}
+	if _, err := os.Stat(path); err == nil && !configInitForce {
+		log.Fatalf("Error: %s already exists, use --force to overwrite it", path)
+	}
+
+	data, err := config.Template()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Fatalf("Error: Failed to write config file: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+}
+
+// configFileKey splits a key of the form profiles.<name>.<key> into the
+// setting and the profile. Other keys use the --profile flag.
+func configFileKey(key string) (string, string) {
+	if rest, ok := strings.CutPrefix(key, "profiles."); ok {
+		if profile, setting, ok := strings.Cut(rest, "."); ok {
+			return setting, profile
+		}
+		log.Fatalf("Error: %s needs a setting, e.g. profiles.%s.limit", key, rest)
+	}
+	return key, config.ActiveProfile()
+}
+
+// profileKey returns the config file key of a setting in a profile
+func profileKey(profile, key string) string {
+	if profile == "" {
+		return key
+	}
+	return "profiles." + profile + "." + key
+}
+
+// configTarget describes the config file, or the profile in it
+func configTarget(path, profile string) string {
+	if profile == "" {
+		return path
+	}
+	return fmt.Sprintf("profile %s of %s", profile, path)
+}
+
+// warnOverridden tells when an environment variable hides a setting that
+// was just changed
+func warnOverridden(key, profile string) {
+	if env := config.EnvVar(key); os.Getenv(env) != "" {
+		fmt.Fprintf(os.Stderr, "Note: %s is set and overrides this value\n", env)
+	}
+	if active := config.ActiveProfile(); profile == "" && active != "" {
+		fmt.Fprintf(os.Stderr, "Note: profile %s is active and may override this value\n", active)
+	}
+}
+
+// formatConfigValue formats a parsed setting value for messages
+func formatConfigValue(value any) string {
+	switch v := value.(type) {
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// editorCommand opens path in the user's editor
+func editorCommand(path string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+
+	var cmd *exec.Cmd
+	switch {
+	case editor == "" && runtime.GOOS == "windows":
+		cmd = exec.Command("notepad", path)
+	case editor == "":
+		cmd = exec.Command("vi", path)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("cmd", "/C", editor+` "`+path+`"`)
+	default:
+		// Run through the shell so that editors with arguments work,
+		// e.g. EDITOR="code --wait"
+		cmd = exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// completeConfigKey completes setting keys
+func completeConfigKey(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var keys []string
+	for _, setting := range config.Schema() {
+		if strings.HasPrefix(setting.Key, toComplete) {
+			keys = append(keys, setting.Key+"\t"+setting.Description)
+		}
+	}
+	return keys, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/metrics"
+	"r34-go/server"
+	"r34-go/services"
+)
+
+// DaemonCmd runs the download queue behind a REST API
+var DaemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a download queue controlled over a REST API",
+	Long: `Run a persistent download queue controlled over HTTP. Jobs use the same
+fields as batch job files and survive restarts; jobs interrupted by a
+shutdown run again when the daemon starts.
+
+Endpoints:
+  POST   /jobs          Submit a job, e.g. {"tags": "animated", "quantity": 50}
+  GET    /jobs          List jobs, optionally ?status=queued|running|done|failed|canceled
+  GET    /jobs/{id}     Job status and live download statistics
+  DELETE /jobs/{id}     Cancel a queued or running job
+  GET    /events        Stream progress events (Server-Sent Events), optionally ?job={id}
+  GET    /metrics       Prometheus metrics, with --metrics
+
+Example:
+  r34-go daemon --listen localhost:8081 --workers 2
+  curl -X POST localhost:8081/jobs -d '{"tags": "hu_tao_(genshin_impact)", "quantity": 20, "output": "./downloads"}'`,
+	Args: cobra.NoArgs,
+	Run:  runDaemon,
+}
+
+var (
+	daemonListen  string
+	daemonWorkers int
+	daemonToken   string
+	daemonQueue   string
+	daemonMetrics bool
+)
+
+func init() {
+	DaemonCmd.Flags().StringVar(&daemonListen, "listen", "localhost:8081", "Address to listen on")
+	DaemonCmd.Flags().IntVarP(&daemonWorkers, "workers", "w", 1, "Number of jobs to run at the same time")
+	DaemonCmd.Flags().StringVar(&daemonToken, "token", "", "Require this bearer token on every request")
+	DaemonCmd.Flags().StringVar(&daemonQueue, "queue", "", "Job queue file (defaults to queue.json in the data directory)")
+	DaemonCmd.Flags().BoolVar(&daemonMetrics, "metrics", false, "Expose Prometheus metrics at /metrics")
+	bindSetting(DaemonCmd.Flags(), "workers", "workers")
+
+	RootCmd.AddCommand(DaemonCmd)
+}
+
+func runDaemon(cmd *cobra.Command, args []string) {
+	if daemonQueue == "" {
+		daemonQueue = services.QueuePath()
+	}
+
+	runner := services.NewJobRunner(services.NewClient())
+	if notifier := newNotifier(); notifier != nil {
+		runner.SetNotifier(notifier, warnHookError)
+	}
+	queue, err := services.OpenJobQueue(daemonQueue, runner)
+	if err != nil {
+		log.Fatalf("Failed to open job queue: %v", err)
+	}
+
+	api := server.NewAPI(queue, daemonToken)
+	if daemonMetrics {
+		registry := metrics.NewRegistry()
+		runner.SetMetrics(registry)
+		api.SetMetrics(registry)
+	}
+
+	if daemonToken == "" && !isLoopback(daemonListen) {
+		fmt.Println("Warning: listening beyond localhost without --token lets anyone on the network start downloads.")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              daemonListen,
+		Handler:           api.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// End event streams on shutdown instead of waiting for them
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		queue.Run(ctx, daemonWorkers)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Daemon listening on http://%s with %d worker(s)\n", displayAddr(daemonListen), daemonWorkers)
+	fmt.Printf("Job queue: %s\n", daemonQueue)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Server failed: %v", err)
+	}
+
+	fmt.Println("Stopping, running jobs will resume on the next start...")
+	wg.Wait()
+}
+
+// isLoopback checks if a listen address only accepts local connections
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
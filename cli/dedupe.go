@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+var dedupeDryRun bool
+
+// DedupeCmd converts output trees to the content store layout
+var DedupeCmd = &cobra.Command{
+	Use:   "dedupe <dir>...",
+	Short: "Move existing downloads into the shared content store",
+	Long: `Move every file of the given output directories into the content store
+keyed by MD5 and replace it with a link. Files with the same content end up
+sharing one copy, and the reclaimed disk space is reported.
+
+Examples:
+  # Deduplicate two job folders into a shared store
+  r34-go dedupe ./downloads/hutao ./downloads/furina --store ./store
+
+  # Show how much space would be reclaimed without changing anything
+  r34-go dedupe ./downloads --store ./store --dry-run`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runDedupe,
+}
+
+func init() {
+	DedupeCmd.Flags().StringVar(&storeDir, "store", "", "Content store directory (defaults to store_dir from config)")
+	DedupeCmd.Flags().StringVar(&linkMode, "link-mode", "", "How to link files: auto, hardlink, symlink or reflink")
+	bindSetting(DedupeCmd.Flags(), "store", "store_dir")
+	bindSetting(DedupeCmd.Flags(), "link-mode", "link_mode")
+	DedupeCmd.Flags().BoolVar(&dedupeDryRun, "dry-run", false, "Only report duplicates, don't change anything")
+
+	RootCmd.AddCommand(DedupeCmd)
+}
+
+func runDedupe(cmd *cobra.Command, args []string) {
+	applyStoreFlags(cmd)
+	if config.AppSettings.StoreDir == "" {
+		log.Fatal("Error: No content store configured, use --store or set store_dir in the config")
+	}
+
+	store := services.NewContentStore(config.AppSettings.StoreDir, config.AppSettings.LinkMode)
+	storeRoot, _ := filepath.Abs(store.Root())
+
+	var files []string
+	for _, dir := range args {
+		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				// Never ingest the store into itself
+				if absPath, _ := filepath.Abs(path); absPath == storeRoot {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Hidden files and metadata sidecars are not downloads
+			if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") && filepath.Ext(d.Name()) != ".json" {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to scan %s: %v", dir, err)
+		}
+	}
+
+	if len(files) == 0 {
+		fmt.Println("No files to deduplicate.")
+		return
+	}
+
+	if dedupeDryRun {
+		reportDuplicates(store, files)
+		return
+	}
+
+	fmt.Printf("Moving %d files into %s\n\n", len(files), store.Root())
+
+	var reclaimed int64
+	var duplicates, failed int
+	bar := newProgressBar(len(files), "Deduplicating...")
+	for i, file := range files {
+		saved, err := store.Ingest(file)
+		if err != nil {
+			log.Printf("Failed to deduplicate %s: %v", file, err)
+			failed++
+		} else if saved > 0 {
+			duplicates++
+			reclaimed += saved
+		}
+		bar.Set(i + 1)
+	}
+	bar.Finish()
+
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("Dedupe Summary:")
+	fmt.Printf("Files processed: %d\n", len(files))
+	fmt.Printf("Duplicates linked: %d\n", duplicates)
+	if failed > 0 {
+		fmt.Printf("Failed: %d\n", failed)
+	}
+	fmt.Printf("Disk space reclaimed: %s\n", utils.FormatFileSize(reclaimed))
+}
+
+func reportDuplicates(store *services.ContentStore, files []string) {
+	seen := make(map[string]bool)
+	var reclaimable int64
+	var duplicates int
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+
+		hash, err := services.FileMD5(file)
+		if err != nil {
+			log.Printf("Failed to hash %s: %v", file, err)
+			continue
+		}
+
+		ext := filepath.Ext(file)
+		key := hash + strings.ToLower(ext)
+
+		if storeInfo, err := os.Stat(store.Path(hash, ext)); err == nil {
+			if !os.SameFile(info, storeInfo) {
+				reclaimable += info.Size()
+				duplicates++
+			}
+		} else if seen[key] {
+			reclaimable += info.Size()
+			duplicates++
+		}
+		seen[key] = true
+	}
+
+	fmt.Printf("Files scanned: %d\n", len(files))
+	fmt.Printf("Duplicates: %d\n", duplicates)
+	fmt.Printf("Disk space that would be reclaimed: %s\n", utils.FormatFileSize(reclaimable))
+}
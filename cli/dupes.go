@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/services"
+)
+
+var dupesDistance int
+
+// DupesCmd lists near-duplicate image clusters in an output directory
+var DupesCmd = &cobra.Command{
+	Use:   "dupes <dir>",
+	Short: "List near-duplicate images in an output directory",
+	Long: `Hash every image in <dir>/Images with a perceptual hash and list groups of
+images that look alike, such as reposts and re-encodes.
+
+Examples:
+  # List near-duplicates using the configured distance
+  r34-go dupes ./downloads
+
+  # Only list very close matches
+  r34-go dupes ./downloads --distance 2`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDupes,
+}
+
+func init() {
+	DupesCmd.Flags().IntVarP(&dupesDistance, "distance", "d", -1, "Maximum hash distance (0-64), defaults to phash_distance from config")
+
+	RootCmd.AddCommand(DupesCmd)
+}
+
+func runDupes(cmd *cobra.Command, args []string) {
+	dir := args[0]
+
+	distance := dupesDistance
+	if distance < 0 {
+		distance = config.AppSettings.PHashDistance
+	}
+
+	fmt.Printf("Hashing images in %s\n", filepath.Join(dir, "Images"))
+
+	index, err := services.LoadPHashIndex(dir)
+	if err != nil {
+		log.Fatalf("Failed to load hash index: %v", err)
+	}
+	if err := index.Save(); err != nil {
+		log.Printf("Failed to save hash index: %v", err)
+	}
+
+	clusters := index.Clusters(distance)
+	if len(clusters) == 0 {
+		fmt.Printf("No near-duplicates found within distance %d.\n", distance)
+		return
+	}
+
+	fmt.Printf("Found %d groups of near-duplicates within distance %d:\n", len(clusters), distance)
+	for i, cluster := range clusters {
+		fmt.Printf("\nGroup %d (%d images):\n", i+1, len(cluster))
+		for _, name := range cluster {
+			fmt.Printf("  %s\n", filepath.Join(dir, "Images", name))
+		}
+	}
+}
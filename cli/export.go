@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/models"
+	"r34-go/services"
+)
+
+// ExportCmd writes search results for other downloaders
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export search results as CSV, JSON lines or aria2 input",
+	Long: `Page through search results and write one record per post instead of
+downloading the files. Quantity, file types, filters and the blacklist apply
+as they do for downloads, and paths follow the filename template and the
+Images/Gif/Video layout.
+
+Formats:
+  csv     id, url, path, md5, rating, score, width, height, created_at, tags
+  jsonl   the post metadata with download_url and path, one object per line
+  aria2   an input file for aria2c -i, with out= set to the path
+  urls    one download URL per line
+
+Examples:
+  r34-go export -t "animated score>100" -q 500 --format aria2 --file posts.aria2
+  aria2c -i posts.aria2 -d ./downloads
+
+  r34-go export -t "hu_tao_(genshin_impact)" --format jsonl --rating s | jq .path`,
+	Args: cobra.NoArgs,
+	Run:  runExport,
+}
+
+var (
+	exportFormat string
+	exportFile   string
+	exportDir    string
+)
+
+func init() {
+	flags := ExportCmd.Flags()
+	flags.StringVarP(&tags, "tags", "t", "", "Tags or tag query to search for (required)")
+	flags.Uint16VarP(&quantity, "quantity", "q", 100, "Number of posts to export")
+	flags.StringVar(&exportFormat, "format", "csv", "Output format: "+strings.Join(services.ExportFormats, ", "))
+	flags.StringVarP(&exportFile, "file", "f", "", "Write to this file instead of stdout")
+	flags.StringVar(&exportDir, "dir", "", "Download directory written to aria2 entries")
+
+	flags.Bool("no-images", false, "Leave out images")
+	flags.Bool("no-gifs", false, "Leave out GIFs")
+	flags.Bool("no-videos", false, "Leave out videos")
+
+	flags.IntVar(&minScore, "min-score", 0, "Skip posts with a lower score")
+	flags.StringSliceVar(&ratings, "rating", nil, "Only keep posts with these ratings, e.g. s,q,e")
+	flags.StringSliceVar(&blacklist, "blacklist", nil, "Skip posts with any of these tags")
+	flags.StringSliceVar(&artists, "artist", nil, "Only keep posts by one of these artists")
+	flags.StringSliceVar(&characters, "character", nil, "Only keep posts with one of these characters")
+	flags.StringSliceVar(&copyrights, "copyright", nil, "Only keep posts from one of these copyrights")
+	flags.StringVar(&filenameTemplate, "filename-template", "", "File name template for paths, e.g. \"{artist}_{id}\"")
+
+	bindSetting(flags, "quantity", "limit")
+	bindInvertedSetting(flags, "no-images", "images")
+	bindInvertedSetting(flags, "no-gifs", "gif")
+	bindInvertedSetting(flags, "no-videos", "video")
+	bindSetting(flags, "min-score", "filters.min_score")
+	bindSetting(flags, "rating", "filters.ratings")
+	bindSetting(flags, "blacklist", "filters.blacklist")
+	bindSetting(flags, "artist", "filters.artists")
+	bindSetting(flags, "character", "filters.characters")
+	bindSetting(flags, "copyright", "filters.copyrights")
+	bindSetting(flags, "filename-template", "filename_template")
+
+	ExportCmd.MarkFlagRequired("tags")
+	ExportCmd.RegisterFlagCompletionFunc("tags", completeQuery)
+	ExportCmd.RegisterFlagCompletionFunc("blacklist", completeTags)
+	ExportCmd.RegisterFlagCompletionFunc("artist", completeTagsOfType(models.TagArtist))
+	ExportCmd.RegisterFlagCompletionFunc("character", completeTagsOfType(models.TagCharacter))
+	ExportCmd.RegisterFlagCompletionFunc("copyright", completeTagsOfType(models.TagCopyright))
+	ExportCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(services.ExportFormats, cobra.ShellCompDirectiveNoFileComp))
+
+	RootCmd.AddCommand(ExportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	options := downloadOptions()
+	if !options.Images && !options.Gif && !options.Video {
+		log.Fatal("Error: At least one file type must be enabled (images, gifs, or videos)")
+	}
+
+	checkFilenameTemplate(filenameTemplate)
+	if !isExportFormat(exportFormat) {
+		log.Fatalf("Error: Unknown --format %q (expected %s)", exportFormat, strings.Join(services.ExportFormats, ", "))
+	}
+
+	// Records go to stdout unless --file is given, messages to stderr
+	var out io.Writer = os.Stdout
+	info := os.Stderr
+	if exportFile != "" {
+		file, err := os.Create(exportFile)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	exporter, err := services.NewExporter(out, exportFormat)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	exporter.SetDir(exportDir)
+
+	plan := compileQuery(tags)
+	services.RecordQuery(tags)
+	options.Query = plan.Filter
+
+	apiService := services.NewAPIService()
+	apiService.SetOptions(options)
+
+	// Show a progress bar when stdout is free
+	var progress func(current, total int)
+	finish := func() {}
+	if exportFile != "" {
+		bar := newProgressBar(int(quantity), "Exporting...")
+		progress = func(current, total int) {
+			bar.Set(current)
+		}
+		finish = func() { bar.Finish() }
+	}
+
+	count, err := apiService.ExportContent(exporter, plan.Tags, quantity, progress)
+	finish()
+	if err != nil {
+		log.Fatalf("Export failed after %d posts: %v", count, err)
+	}
+
+	if exportFile != "" {
+		fmt.Fprintf(info, "\nExported %d posts to %s\n", count, exportFile)
+	} else {
+		fmt.Fprintf(info, "Exported %d posts\n", count)
+	}
+}
+
+func isExportFormat(format string) bool {
+	for _, f := range services.ExportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/models"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// htmlListPageSize is the number of posts shown on a site list page
+const htmlListPageSize = 42
+
+// GetCmd downloads posts by ID or URL
+var GetCmd = &cobra.Command{
+	Use:   "get <id|url>...",
+	Short: "Download posts by ID or URL",
+	Long: `Download single posts by ID or post URL, or the posts shown on a list URL.
+
+Examples:
+  # Download a post by ID
+  r34-go get 123456
+
+  # Download a post from its URL
+  r34-go get "https://rule34.xxx/index.php?page=post&s=view&id=123456"
+
+  # Download the posts shown on a list page
+  r34-go get "https://rule34.xxx/index.php?page=post&s=list&tags=animated&pid=42"`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runGet,
+}
+
+func init() {
+	GetCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory")
+	bindSetting(GetCmd.Flags(), "output", "output")
+
+	RootCmd.AddCommand(GetCmd)
+}
+
+func runGet(cmd *cobra.Command, args []string) {
+	apiService := services.NewAPIService()
+
+	var posts []models.Post
+	for _, arg := range args {
+		resolved, err := resolvePosts(apiService, arg)
+		if err != nil {
+			log.Printf("Skipping %s: %v", arg, err)
+			continue
+		}
+		posts = append(posts, resolved...)
+	}
+
+	if len(posts) == 0 {
+		fmt.Println("No posts found for the specified IDs or URLs.")
+		return
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	fmt.Printf("Downloading %d posts\n", len(posts))
+	fmt.Printf("Output directory: %s\n", outputDir)
+	fmt.Println()
+
+	bar := newProgressBar(len(posts), "Downloading...")
+	stats, err := apiService.DownloadPosts(posts, outputDir, func(current, total int) {
+		bar.Set(current)
+	})
+	bar.Finish()
+	if err != nil {
+		log.Fatalf("Download failed: %v", err)
+	}
+
+	printDownloadSummary(stats, outputDir)
+}
+
+// resolvePosts turns a post ID, post URL or list URL into API posts
+func resolvePosts(apiService *services.APIService, arg string) ([]models.Post, error) {
+	if utils.IsPostID(arg) {
+		post, err := apiService.GetPost(arg)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Post{*post}, nil
+	}
+
+	if !strings.Contains(arg, "page=post") {
+		return nil, fmt.Errorf("not a post ID or post URL")
+	}
+
+	if strings.Contains(arg, "s=view") {
+		id := utils.ExtractPostIDFromURL(arg)
+		if id == "" {
+			return nil, fmt.Errorf("post URL has no id parameter")
+		}
+		post, err := apiService.GetPost(id)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Post{*post}, nil
+	}
+
+	// List URLs use pid as a post offset, while the API uses page numbers.
+	// An offset inside a page needs that page and the next one.
+	listTags := utils.ExtractTagsFromURL(arg)
+	offset := utils.ParsePageFromURL(arg)
+	page, skip := offset/htmlListPageSize, offset%htmlListPageSize
+
+	posts, err := apiService.GetPosts(listTags, page, htmlListPageSize)
+	if err != nil || skip == 0 {
+		return posts, err
+	}
+	if len(posts) == htmlListPageSize {
+		next, err := apiService.GetPosts(listTags, page+1, htmlListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, next...)
+	}
+	if skip >= len(posts) {
+		return nil, nil
+	}
+	return posts[skip:min(skip+htmlListPageSize, len(posts))], nil
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/services"
+)
+
+// HooksCmd groups the notification hook commands
+var HooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage job notification hooks",
+	Long: `Notification hooks run when a download, batch job or daemon job finishes.
+A hook either posts the job report to a URL (as plain JSON, a Discord webhook
+message or an ntfy notification) or runs a command with the report in
+R34_HOOK_* environment variables (R34_HOOK_EVENT, R34_HOOK_JOB,
+R34_HOOK_DOWNLOADED, ...) and as JSON on stdin.
+
+Hooks are configured in config.yaml:
+  hooks:
+    - url: https://discord.com/api/webhooks/<id>/<token>
+      format: discord
+    - url: https://ntfy.sh/my-topic
+      format: ntfy
+      on: [failed]
+    - command: 'notify-send "r34-go" "$R34_HOOK_JOB: $R34_HOOK_DOWNLOADED new files"'`,
+}
+
+// HooksListCmd lists the configured hooks
+var HooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the configured hooks",
+	Args:  cobra.NoArgs,
+	Run:   runHooksList,
+}
+
+// HooksTestCmd sends a sample report to the hooks
+var HooksTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a sample job report to the hooks",
+	Long: `Send a sample job report to every configured hook, or only to the hook
+given with --url or --command.
+
+Examples:
+  # Print the JSON report a command hook receives
+  r34-go hooks test --command cat
+
+  # Send a sample notification to an ntfy topic
+  r34-go hooks test --url https://ntfy.sh/my-topic --format ntfy`,
+	Args: cobra.NoArgs,
+	Run:  runHooksTest,
+}
+
+var (
+	hookTestURL     string
+	hookTestCommand string
+	hookTestFormat  string
+	hookTestEvent   string
+)
+
+func init() {
+	HooksTestCmd.Flags().StringVar(&hookTestURL, "url", "", "Post to this URL instead of the configured hooks")
+	HooksTestCmd.Flags().StringVar(&hookTestCommand, "command", "", "Run this command instead of the configured hooks")
+	HooksTestCmd.Flags().StringVar(&hookTestFormat, "format", "json", "Payload format for --url: json, discord or ntfy")
+	HooksTestCmd.Flags().StringVar(&hookTestEvent, "event", "done", "Sample event to send: done or failed")
+
+	HooksCmd.AddCommand(HooksListCmd)
+	HooksCmd.AddCommand(HooksTestCmd)
+	RootCmd.AddCommand(HooksCmd)
+}
+
+func runHooksList(cmd *cobra.Command, args []string) {
+	if len(config.AppSettings.Hooks) == 0 {
+		fmt.Println("No hooks configured.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tFORMAT\tON")
+	for _, hook := range config.AppSettings.Hooks {
+		target, format := hook.URL, hook.Format
+		if hook.Command != "" {
+			target, format = "$ "+hook.Command, "command"
+		} else if format == "" {
+			format = "json"
+		}
+
+		on := "done, failed"
+		if len(hook.On) > 0 {
+			on = strings.Join(hook.On, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", target, format, on)
+	}
+	w.Flush()
+}
+
+func runHooksTest(cmd *cobra.Command, args []string) {
+	hooks := config.AppSettings.Hooks
+	if hookTestURL != "" || hookTestCommand != "" {
+		hooks = []config.HookSettings{{URL: hookTestURL, Command: hookTestCommand, Format: hookTestFormat}}
+	}
+	if len(hooks) == 0 {
+		log.Fatal("Error: No hooks configured, pass --url or --command to test one")
+	}
+	for _, hook := range hooks {
+		if err := services.ValidateHook(hook); err != nil {
+			log.Fatalf("Error: Invalid hook: %v", err)
+		}
+	}
+
+	report := sampleReport(hookTestEvent)
+	if err := services.NewNotifier(hooks).Notify(report); err != nil {
+		log.Fatalf("Hook test failed: %v", err)
+	}
+	fmt.Printf("Sent a sample %q report to %d hook(s).\n", report.Event, len(hooks))
+}
+
+// sampleReport is the report sent by hooks test
+func sampleReport(event string) models.JobReport {
+	if event != "done" && event != "failed" {
+		log.Fatalf("Error: Invalid --event %q (expected done or failed)", event)
+	}
+
+	started := time.Now().Add(-time.Minute)
+	job := models.Job{Name: "hook test", Tags: "hu_tao_(genshin_impact) rating:s", Output: "./downloads"}
+	stats := &models.DownloadStats{Total: 3, Downloaded: 2, Skipped: 1, Images: 1, Videos: 1}
+	files := []string{"downloads/Images/1000001.jpg", "downloads/Video/1000002.mp4"}
+
+	var err error
+	if event == "failed" {
+		err = fmt.Errorf("failed to fetch page 1: bad status: 503 Service Unavailable")
+	}
+	return services.NewJobReport(job, stats, err, files, started)
+}
+
+// newNotifier creates a notifier for the configured hooks, or nil when
+// there are none
+func newNotifier() *services.Notifier {
+	if len(config.AppSettings.Hooks) == 0 {
+		return nil
+	}
+	for _, hook := range config.AppSettings.Hooks {
+		if err := services.ValidateHook(hook); err != nil {
+			log.Fatalf("Error: Invalid hook in config: %v", err)
+		}
+	}
+	return services.NewNotifier(config.AppSettings.Hooks)
+}
+
+// warnHookError reports a failed hook without failing the download
+func warnHookError(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+}
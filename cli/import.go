@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// maxListedUnmatched limits the unmatched files printed after an import
+const maxListedUnmatched = 20
+
+// ImportCmd indexes a collection saved by other tools
+var ImportCmd = &cobra.Command{
+	Use:   "import <dir>",
+	Short: "Index files saved by other tools so downloads recognize them",
+	Long: `Look up the files of a directory on the site and add them to the library
+index with their metadata. Each file is matched by its MD5, or by the MD5 or
+post ID in its name, so files named 123456.jpg or <md5>.png from older tools
+are found even when they were converted.
+
+By default files stay where they are and are indexed in place. With --move
+they are moved into the output directory under the Images/Gif/Video layout
+and the filename template, so later downloads of the same posts skip them.
+
+Examples:
+  # Index an old collection in place
+  r34-go import ./old-downloads
+
+  # See where files would go, then move them into the configured output
+  r34-go import ./old-downloads --move --dry-run
+  r34-go import ./old-downloads --move`,
+	Args: cobra.ExactArgs(1),
+	Run:  runImport,
+}
+
+var (
+	importOutput string
+	importMove   bool
+	importDryRun bool
+)
+
+func init() {
+	ImportCmd.Flags().StringVarP(&importOutput, "output", "o", "", "Output directory to index into (defaults to <dir>, or the configured output with --move)")
+	ImportCmd.Flags().BoolVar(&importMove, "move", false, "Move matched files into the download layout of the output directory")
+	ImportCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Only report matches, don't index or move anything")
+	ImportCmd.Flags().BoolVar(&sidecar, "sidecar", true, "Write a <file>.json with the post metadata next to each file")
+	ImportCmd.Flags().StringVar(&filenameTemplate, "filename-template", "", "File name template for moved files, e.g. \"{artist}_{id}\"")
+	bindSetting(ImportCmd.Flags(), "filename-template", "filename_template")
+
+	RootCmd.AddCommand(ImportCmd)
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Fatalf("Error: %s is not a directory", dir)
+	}
+
+	checkFilenameTemplate(filenameTemplate)
+
+	output := importOutput
+	if output == "" {
+		output = dir
+		if importMove {
+			output = config.AppSettings.Output
+		}
+	}
+
+	files, err := mediaFiles(dir)
+	if err != nil {
+		log.Fatalf("Failed to scan %s: %v", dir, err)
+	}
+	if len(files) == 0 {
+		fmt.Println("No media files to import.")
+		return
+	}
+
+	options := services.OptionsFromSettings()
+	options.Sidecar = sidecar
+	options.FilenameTemplate = filenameTemplate
+
+	importer, err := services.NewImporter(services.NewAPIService(), output, options, importMove, importDryRun)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	fmt.Printf("Importing %d files from %s\n", len(files), dir)
+	fmt.Printf("Output directory: %s\n", output)
+	if importDryRun {
+		fmt.Println("Dry run, nothing is changed")
+	}
+	fmt.Println()
+
+	counts := make(map[string]int)
+	var moved int
+	var unmatched, problems []string
+
+	bar := newProgressBar(len(files), "Importing...")
+	for i, file := range files {
+		result := importer.Import(file)
+		counts[result.Status]++
+
+		switch result.Status {
+		case services.ImportMatched:
+			if result.Reason != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", file, result.Reason))
+			}
+			if result.Path != result.Source {
+				moved++
+				if importDryRun {
+					bar.Clear()
+					fmt.Printf("%s -> %s (post %s, by %s)\n", result.Source, result.Path, result.Post.ID, result.MatchedBy)
+				}
+			}
+		case services.ImportUnmatched:
+			unmatched = append(unmatched, file)
+		case services.ImportConflict, services.ImportFailed:
+			problems = append(problems, fmt.Sprintf("%s: %s", file, result.Reason))
+		}
+		bar.Set(i + 1)
+	}
+	bar.Finish()
+	if err := importer.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("Import Summary:")
+	fmt.Printf("Files scanned: %d\n", len(files))
+	fmt.Printf("Matched: %d\n", counts[services.ImportMatched])
+	if importMove {
+		verb := "Moved"
+		if importDryRun {
+			verb = "Would move"
+		}
+		fmt.Printf("%s: %d\n", verb, moved)
+	}
+	fmt.Printf("Already indexed: %d\n", counts[services.ImportIndexed])
+	fmt.Printf("Unmatched: %d\n", counts[services.ImportUnmatched])
+	if len(problems) > 0 {
+		fmt.Printf("Conflicts or failures: %d\n", len(problems))
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	if len(unmatched) > 0 {
+		fmt.Println("\nNo post found for:")
+		for i, file := range unmatched {
+			if i == maxListedUnmatched {
+				fmt.Printf("  ... and %d more\n", len(unmatched)-i)
+				break
+			}
+			fmt.Printf("  %s\n", file)
+		}
+	}
+}
+
+// mediaFiles lists the media files below dir, leaving out indexes,
+// metadata sidecars and view links
+func mediaFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") && utils.IsValidFileExtension(filepath.Ext(d.Name())) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
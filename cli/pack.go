@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"r34-go/config"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// PackCmd bundles an output directory into archives
+var PackCmd = &cobra.Command{
+	Use:   "pack [dir]",
+	Short: "Bundle a download directory into zip, tar.gz or CBZ archives",
+	Long: `Stream the media files of an output directory into an archive, with a
+manifest.json listing the post of every file from the library index or its
+metadata sidecar. The directory defaults to the configured output.
+
+With --split the files go to numbered parts of at most that size, e.g.
+hutao-001.zip and hutao-002.zip. CBZ archives hold a flat list of pages in
+file name order for comic readers.
+
+Downloads can be archived as they run with --archive, see r34-go --help.
+
+Examples:
+  r34-go pack ./downloads/hutao
+  r34-go pack ./downloads/hutao --format tar.gz --split 2GB --remove
+  r34-go pack ./downloads/pool --format cbz -o ./comics/pool.cbz`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runPack,
+}
+
+var (
+	packFormat string
+	packOutput string
+	packRemove bool
+)
+
+func init() {
+	PackCmd.Flags().StringVar(&packFormat, "format", "zip", "Archive format: "+strings.Join(services.ArchiveFormats, ", "))
+	PackCmd.Flags().StringVarP(&packOutput, "output", "o", "", "Archive path (defaults to <dir>.<format> next to the directory)")
+	PackCmd.Flags().String("split", "", "Largest size of an archive part, e.g. 500MB")
+	PackCmd.Flags().BoolVar(&packRemove, "remove", false, "Delete the packed files and their sidecars once archived")
+	bindSetting(PackCmd.Flags(), "split", "archive.split")
+	PackCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(services.ArchiveFormats, cobra.ShellCompDirectiveNoFileComp))
+
+	RootCmd.AddCommand(PackCmd)
+}
+
+// addArchiveFlags adds the flags archiving the files of download jobs
+func addArchiveFlags(flags *pflag.FlagSet) {
+	flags.String("archive", "", "Bundle the downloaded files into an archive: "+strings.Join(services.ArchiveFormats, ", "))
+	flags.String("archive-split", "", "Largest size of an archive part, e.g. 500MB")
+	flags.Bool("archive-only", false, "Stream downloads straight into the archive without keeping loose files")
+	bindSetting(flags, "archive", "archive.format")
+	bindSetting(flags, "archive-split", "archive.split")
+	bindInvertedSetting(flags, "archive-only", "archive.keep_files")
+}
+
+// checkArchiveSettings stops on an unknown archive format or split size
+func checkArchiveSettings() {
+	settings := config.AppSettings.Archive
+	if settings.Format != "" && settings.Format != "none" && !isArchiveFormat(settings.Format) {
+		log.Fatalf("Error: Unknown --archive %q (expected %s)", settings.Format, strings.Join(services.ArchiveFormats, ", "))
+	}
+	if settings.Split != "" {
+		if _, err := utils.ParseFileSize(settings.Split); err != nil {
+			log.Fatalf("Error: Invalid archive split: %v", err)
+		}
+	}
+}
+
+func isArchiveFormat(format string) bool {
+	for _, f := range services.ArchiveFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// describeArchives names the archives of a run for a one-line report
+func describeArchives(archives []string) string {
+	if len(archives) == 1 {
+		return archives[0]
+	}
+	return fmt.Sprintf("%d parts, %s to %s", len(archives), archives[0], archives[len(archives)-1])
+}
+
+func runPack(cmd *cobra.Command, args []string) {
+	dir := config.AppSettings.Output
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Fatalf("Error: %s is not a directory", dir)
+	}
+
+	if !isArchiveFormat(packFormat) {
+		log.Fatalf("Error: Unknown --format %q (expected %s)", packFormat, strings.Join(services.ArchiveFormats, ", "))
+	}
+	var split int64
+	if config.AppSettings.Archive.Split != "" {
+		var err error
+		if split, err = utils.ParseFileSize(config.AppSettings.Archive.Split); err != nil {
+			log.Fatalf("Error: Invalid --split: %v", err)
+		}
+	}
+
+	base := services.ArchiveBase(dir, packFormat)
+	if packOutput != "" {
+		base = strings.TrimSuffix(packOutput, "."+packFormat)
+	}
+
+	files, err := mediaFiles(dir)
+	if err != nil {
+		log.Fatalf("Failed to scan %s: %v", dir, err)
+	}
+	if len(files) == 0 {
+		fmt.Println("No media files to pack.")
+		return
+	}
+
+	archive, err := services.NewArchiveWriter(base, packFormat, split)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	archive.SetRemoveFiles(packRemove)
+
+	fmt.Printf("Packing %d files from %s\n\n", len(files), dir)
+
+	bar := newProgressBar(len(files), "Packing...")
+	packed, err := archive.Pack(dir, files, func(current, total int) {
+		bar.Set(current)
+	})
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	bar.Finish()
+	if err != nil {
+		log.Fatalf("Packing failed after %d files: %v", packed, err)
+	}
+
+	printPackSummary(packed, archive.Paths())
+}
+
+func printPackSummary(packed int, archives []string) {
+	fmt.Println("\n" + strings.Repeat("=", 50))
+	fmt.Println("Pack Summary:")
+	if packRemove {
+		fmt.Printf("Files packed and removed: %d\n", packed)
+	} else {
+		fmt.Printf("Files packed: %d\n", packed)
+	}
+
+	fmt.Println("\nArchives:")
+	for _, archive := range archives {
+		size := "?"
+		if info, err := os.Stat(archive); err == nil {
+			size = utils.FormatFileSize(info.Size())
+		}
+		fmt.Printf("  %s (%s)\n", archive, size)
+	}
+}
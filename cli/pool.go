@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/services"
+)
+
+var poolCBZ bool
+
+// PoolCmd downloads a pool in reading order
+var PoolCmd = &cobra.Command{
+	Use:   "pool <pool-id>",
+	Short: "Download a pool in reading order",
+	Long: `Download every post of a pool into <output>/pools/<pool name>/ with
+zero-padded sequence numbers so the files sort in reading order.
+
+Examples:
+  # Download pool 12345
+  r34-go pool 12345 -o "your_path"
+
+  # Download pool 12345 and bundle it as a CBZ
+  r34-go pool 12345 --cbz`,
+	Args: cobra.ExactArgs(1),
+	Run:  runPool,
+}
+
+func init() {
+	PoolCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory")
+	bindSetting(PoolCmd.Flags(), "output", "output")
+	PoolCmd.Flags().BoolVar(&poolCBZ, "cbz", false, "Bundle the finished pool as a CBZ archive")
+
+	RootCmd.AddCommand(PoolCmd)
+}
+
+func runPool(cmd *cobra.Command, args []string) {
+	poolService := services.NewPoolService()
+
+	pool, err := poolService.GetPool(args[0])
+	if err != nil {
+		log.Fatalf("Failed to load pool: %v", err)
+	}
+
+	fmt.Printf("Downloading pool: %s (%d posts)\n", pool.Name, len(pool.PostIDs))
+	fmt.Printf("Output directory: %s\n", outputDir)
+	fmt.Println()
+
+	bar := newProgressBar(len(pool.PostIDs), "Downloading...")
+	stats, err := poolService.DownloadPool(pool, outputDir, poolCBZ, func(current, total int) {
+		bar.Set(current)
+	})
+	if err != nil {
+		log.Fatalf("Pool download failed: %v", err)
+	}
+
+	bar.Finish()
+
+	printDownloadStats(&stats.DownloadStats)
+	fmt.Printf("\nPool saved to: %s\n", stats.Directory)
+	if stats.Archive != "" {
+		fmt.Printf("CBZ archive: %s\n", stats.Archive)
+	}
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"r34-go/config"
+)
+
+const (
+	// settingAnnotation marks a flag that overrides a setting
+	settingAnnotation = "r34_setting"
+	// invertedSettingAnnotation marks a flag that turns a setting off
+	invertedSettingAnnotation = "r34_setting_inverted"
+)
+
+var profileName string
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Configuration profile to use (defaults to $R34_PROFILE)")
+}
+
+// bindSetting makes a flag override a setting when given and default to
+// the setting otherwise
+func bindSetting(flags *pflag.FlagSet, name, key string) {
+	flags.SetAnnotation(name, settingAnnotation, []string{key})
+}
+
+// bindInvertedSetting makes a flag turn a setting off when given
+func bindInvertedSetting(flags *pflag.FlagSet, name, key string) {
+	flags.SetAnnotation(name, invertedSettingAnnotation, []string{key})
+}
+
+// applyConfigLayers merges the profile, the R34_* environment and the flags
+// of the running command over the config file, then fills the flags that
+// were not given with the effective settings
+func applyConfigLayers(cmd *cobra.Command, args []string) {
+	profile := profileName
+	if profile == "" {
+		profile = os.Getenv("R34_PROFILE")
+	}
+	if err := config.UseProfile(profile); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	flags := cmd.Flags()
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if key := flag.Annotations[settingAnnotation]; key != nil {
+			config.BindFlag(key[0], flag)
+		}
+		if key := flag.Annotations[invertedSettingAnnotation]; key != nil && flag.Changed {
+			config.SetFromFlag(key[0], flag.Value.String() != "true", flag.Name)
+		}
+	})
+
+	if err := config.Reload(); err != nil {
+		log.Fatalf("Error: Invalid configuration: %v", err)
+	}
+	setupResponseCache(cmd)
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		key := flag.Annotations[settingAnnotation]
+		if key == nil || flag.Changed {
+			return
+		}
+
+		value := viper.GetString(key[0])
+		if flag.Value.Type() == "stringSlice" {
+			value = strings.Join(viper.GetStringSlice(key[0]), ",")
+		}
+		if err := flag.Value.Set(value); err != nil {
+			log.Fatalf("Error: Invalid setting %s for --%s: %v", key[0], flag.Name, err)
+		}
+	})
+}
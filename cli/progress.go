@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"r34-go/events"
+	"r34-go/models"
+)
+
+// progressMode selects how download progress is reported: bar, json or none
+var progressMode string
+
+// newProgressSink returns the event sink reporting progress in the selected
+// mode and a function to call once the run is over
+func newProgressSink(total int, description string) (models.EventSink, func()) {
+	switch progressMode {
+	case "bar":
+		bar := newProgressBar(total, description)
+		sink := events.Func(func(event models.Event) {
+			switch event.Type {
+			case models.EventFileDone, models.EventPostSkipped, models.EventFileFailed:
+				if event.Current > 0 {
+					bar.Set(event.Current)
+				}
+			}
+			// Files are kept despite a failed near-duplicate check
+			if event.Type == models.EventFileDone && event.Reason != "" {
+				fmt.Fprintf(os.Stderr, "\nWarning: %s\n", event.Reason)
+			}
+		})
+		return sink, func() { bar.Finish() }
+	case "json":
+		return events.NewJSONLines(os.Stdout), func() {}
+	case "none":
+		return nil, func() {}
+	default:
+		log.Fatalf("Error: Invalid --progress %q (expected bar, json or none)", progressMode)
+		return nil, nil
+	}
+}
+
+// infoOutput returns where to print informational messages, keeping stdout
+// free for events in json mode
+func infoOutput() io.Writer {
+	if progressMode == "json" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/server"
+)
+
+// ServeCmd serves a web gallery of an output directory
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Browse downloads in a local web gallery",
+	Long: `Start a web server showing the files of an output directory as a paginated
+gallery. Files can be searched with the tag query syntax, videos play in the
+page, and every file has a detail page with its saved post data.
+
+Metadata comes from the library index of the output directory, or from
+metadata sidecars for files downloaded before the index existed.
+
+Example:
+  r34-go serve -o ./downloads --listen :8080`,
+	Args: cobra.NoArgs,
+	Run:  runServe,
+}
+
+var listenAddr string
+
+func init() {
+	ServeCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory to serve")
+	bindSetting(ServeCmd.Flags(), "output", "output")
+	ServeCmd.Flags().StringVar(&listenAddr, "listen", "localhost:8080", "Address to listen on")
+
+	RootCmd.AddCommand(ServeCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+		log.Fatalf("Error: %s is not a directory", outputDir)
+	}
+
+	gallery, err := server.NewGallery(outputDir)
+	if err != nil {
+		log.Fatalf("Failed to create gallery: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           gallery.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Printf("Serving %s on http://%s\n", outputDir, displayAddr(listenAddr))
+	fmt.Println("Press Ctrl+C to stop.")
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// displayAddr turns a listen address such as :8080 into one that can be
+// opened in a browser
+func displayAddr(addr string) string {
+	if len(addr) > 0 && addr[0] == ':' {
+		return "localhost" + addr
+	}
+	return addr
+}
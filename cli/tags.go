@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/services"
+)
+
+// TagsCmd groups the tag lookup commands
+var TagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "Look up tag information",
+	Long:  `Look up tag categories and post counts`,
+}
+
+// TagsInfoCmd shows the category and post count of tags
+var TagsInfoCmd = &cobra.Command{
+	Use:   "info <tag>...",
+	Short: "Show the category and post count of tags",
+	Long: `Show whether tags are artists, characters, copyrights, general or meta tags
+and how many posts use them. Results are cached locally.
+
+Example:
+  r34-go tags info "hu_tao_(genshin_impact)" genshin_impact`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeTags,
+	Run:               runTagsInfo,
+}
+
+// TagsSuggestCmd completes a tag prefix
+var TagsSuggestCmd = &cobra.Command{
+	Use:   "suggest <prefix>",
+	Short: "Suggest tags starting with a prefix",
+	Long: `Suggest tags starting with a prefix using the site autocomplete.
+
+Example:
+  r34-go tags suggest hu_tao`,
+	Args: cobra.ExactArgs(1),
+	Run:  runTagsSuggest,
+}
+
+// TagsRelatedCmd lists tags that often appear together with a tag
+var TagsRelatedCmd = &cobra.Command{
+	Use:   "related <tag>",
+	Short: "List tags that often appear together with a tag",
+	Long: `Sample the first pages of results for a tag and list the tags that appear
+most often on the same posts.
+
+Example:
+  r34-go tags related "hu_tao_(genshin_impact)" --pages 5`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTags,
+	Run:               runTagsRelated,
+}
+
+var (
+	tagsLimit int
+	tagsPages int
+)
+
+func init() {
+	TagsSuggestCmd.Flags().IntVarP(&tagsLimit, "limit", "l", 10, "Maximum number of suggestions")
+	TagsRelatedCmd.Flags().IntVarP(&tagsLimit, "limit", "l", 25, "Maximum number of related tags")
+	TagsRelatedCmd.Flags().IntVarP(&tagsPages, "pages", "p", 3, "Number of result pages to sample")
+
+	TagsCmd.AddCommand(TagsInfoCmd)
+	TagsCmd.AddCommand(TagsSuggestCmd)
+	TagsCmd.AddCommand(TagsRelatedCmd)
+	RootCmd.AddCommand(TagsCmd)
+}
+
+func runTagsInfo(cmd *cobra.Command, args []string) {
+	tagService := services.NewTagService()
+
+	tags, err := tagService.Lookup(args)
+	if err != nil {
+		log.Fatalf("Failed to look up tags: %v", err)
+	}
+	tagService.SaveCache()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tTYPE\tPOSTS")
+	for _, name := range args {
+		tag := tags[name]
+		fmt.Fprintf(w, "%s\t%s\t%d\n", name, tag.Type, tag.Count)
+	}
+	w.Flush()
+}
+
+func runTagsSuggest(cmd *cobra.Command, args []string) {
+	tagService := services.NewTagService()
+	suggestions, err := tagService.Suggest(args[0], tagsLimit)
+	if err != nil {
+		log.Fatalf("Failed to get suggestions: %v", err)
+	}
+	tagService.SaveCache()
+
+	if len(suggestions) == 0 {
+		fmt.Printf("No tags found starting with %s\n", args[0])
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tTYPE\tPOSTS")
+	for _, tag := range suggestions {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", tag.Name, tag.Type, tag.Count)
+	}
+	w.Flush()
+}
+
+func runTagsRelated(cmd *cobra.Command, args []string) {
+	related, sampled, err := services.NewTagService().Related(args[0], tagsPages, tagsLimit)
+	if err != nil {
+		log.Fatalf("Failed to get related tags: %v", err)
+	}
+
+	if sampled == 0 {
+		fmt.Printf("No posts found for %s\n", args[0])
+		printSuggestions(args[0])
+		return
+	}
+
+	fmt.Printf("Tags found together with %s in %d posts:\n\n", args[0], sampled)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tTYPE\tTOGETHER\tPOSTS")
+	for _, tag := range related {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", tag.Name, tag.Type, tag.Together, tag.Count)
+	}
+	w.Flush()
+}
+
+// printSuggestions prints "did you mean" suggestions for every tag of a
+// query that has no posts of its own
+func printSuggestions(query string) {
+	tagService := services.NewTagService()
+
+	for _, tag := range strings.Fields(query) {
+		// Negated tags, meta tags like score:>10, wildcards and OR groups
+		// can't be misspelled tags
+		if strings.HasPrefix(tag, "-") || strings.ContainsAny(tag, ":*") || tag == "(" || tag == ")" || tag == "~" {
+			continue
+		}
+
+		if info, err := tagService.Lookup([]string{tag}); err == nil && info[tag].Count > 0 {
+			continue
+		}
+
+		suggestions, err := tagService.DidYouMean(tag, 5)
+		if err != nil || len(suggestions) == 0 {
+			continue
+		}
+
+		fmt.Printf("\nDid you mean (instead of %s):\n", tag)
+		for _, suggestion := range suggestions {
+			fmt.Printf("  %s (%d posts)\n", suggestion.Name, suggestion.Count)
+		}
+	}
+	tagService.SaveCache()
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/services"
+)
+
+var (
+	viewTags     []string
+	viewArtists  []string
+	viewMinCount int
+)
+
+// ViewsCmd groups the tag-based view commands
+var ViewsCmd = &cobra.Command{
+	Use:   "views",
+	Short: "Manage tag-based symlink views of downloads",
+	Long: `Manage directory trees of symlinks that group downloaded files by tag,
+artist and rating, built from the library index of an output directory:
+
+  <output>/views/by-tag/<tag>/
+  <output>/views/by-artist/<artist>/
+  <output>/views/by-rating/<rating>/
+
+Set views.auto in the config to update the views after every download.`,
+}
+
+// ViewsBuildCmd rebuilds the views of an output directory
+var ViewsBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Rebuild the symlink views of an output directory",
+	Long: `Remove the existing views of an output directory and create them again
+from its library index.
+
+Examples:
+  # Build views for every tag used by at least 5 posts
+  r34-go views build -o ./downloads --min-count 5
+
+  # Only build views for some tags and artists
+  r34-go views build -o ./downloads --tag "hu_tao_(genshin_impact)" --artist some_artist`,
+	Args: cobra.NoArgs,
+	Run:  runViewsBuild,
+}
+
+func init() {
+	ViewsBuildCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads", "Output directory")
+	bindSetting(ViewsBuildCmd.Flags(), "output", "output")
+	ViewsBuildCmd.Flags().StringSliceVar(&viewTags, "tag", nil, "Only build by-tag views for these tags (defaults to views.tags from config)")
+	ViewsBuildCmd.Flags().StringSliceVar(&viewArtists, "artist", nil, "Tags to treat as artists (defaults to views.artists from config)")
+	ViewsBuildCmd.RegisterFlagCompletionFunc("tag", completeTags)
+	ViewsBuildCmd.RegisterFlagCompletionFunc("artist", completeTagsOfType(models.TagArtist))
+	ViewsBuildCmd.Flags().IntVar(&viewMinCount, "min-count", 0, "Minimum posts for a tag to get a view when no tags are listed (defaults to views.min_count from config)")
+
+	ViewsCmd.AddCommand(ViewsBuildCmd)
+	RootCmd.AddCommand(ViewsCmd)
+}
+
+func runViewsBuild(cmd *cobra.Command, args []string) {
+	settings := config.AppSettings.Views
+	if cmd.Flag("tag").Changed {
+		settings.Tags = viewTags
+	}
+	if cmd.Flag("artist").Changed {
+		settings.Artists = viewArtists
+	}
+	if cmd.Flag("min-count").Changed {
+		settings.MinCount = viewMinCount
+	}
+
+	library, err := services.OpenLibrary(outputDir)
+	if err != nil {
+		log.Fatalf("Failed to open library index: %v", err)
+	}
+
+	entries := library.Entries()
+	if len(entries) == 0 {
+		fmt.Printf("No indexed downloads found in %s.\n", outputDir)
+		return
+	}
+
+	fmt.Printf("Building views for %d files\n", len(entries))
+
+	links, err := services.NewViewBuilder(library, settings).Build()
+	if err != nil {
+		log.Fatalf("Failed to build views: %v", err)
+	}
+
+	fmt.Printf("✓ Created %d links in %s\n", links, filepath.Join(outputDir, "views"))
+}
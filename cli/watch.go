@@ -0,0 +1,297 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"r34-go/config"
+	"r34-go/metrics"
+	"r34-go/models"
+	"r34-go/schedule"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// WatchCmd runs the configured subscriptions on their schedules
+var WatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Run subscriptions on their schedules",
+	Long: `Run the subscriptions from config.yaml in the foreground, each on its own
+schedule. A schedule is a cron expression (minute hour day month weekday), a
+descriptor such as @hourly, @daily or @weekly, or an interval such as 6h.
+
+Subscriptions take the same fields as batch jobs. The last run of each one is
+saved, so a run missed while watch was stopped happens right after it starts.
+A run that is still going when the next one is due skips that next run.
+
+Example config:
+  subscriptions:
+    - name: hutao
+      tags: "hu_tao_(genshin_impact)"
+      quantity: 50
+      output: ./downloads/hutao
+      schedule: "0 3 * * *"
+    - name: animated
+      tags: "animated score:>100"
+      schedule: 6h
+      jitter: 10m`,
+	Args: cobra.NoArgs,
+	Run:  runWatch,
+}
+
+var (
+	watchJitter        time.Duration
+	watchState         string
+	watchMetricsListen string
+	watchList          bool
+)
+
+func init() {
+	WatchCmd.Flags().DurationVar(&watchJitter, "jitter", time.Minute, "Delay each run by a random duration up to this long, unless the subscription sets its own")
+	WatchCmd.Flags().StringVar(&watchState, "state", "", "Watch state file (defaults to watch_state.json in the data directory)")
+	WatchCmd.Flags().StringVar(&watchMetricsListen, "metrics-listen", "", "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9134")
+	WatchCmd.Flags().BoolVar(&watchList, "list", false, "List the subscriptions with their last and next runs and exit")
+
+	RootCmd.AddCommand(WatchCmd)
+}
+
+// subscription is a configured subscription with its parsed schedule
+type subscription struct {
+	models.Subscription
+	schedule schedule.Schedule
+}
+
+func runWatch(cmd *cobra.Command, args []string) {
+	subs, err := loadSubscriptions(config.AppSettings.Subscriptions)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(subs) == 0 {
+		fmt.Println("No subscriptions configured. Add them under subscriptions: in config.yaml.")
+		return
+	}
+
+	if watchState == "" {
+		watchState = services.WatchStatePath()
+	}
+	state, err := services.LoadWatchState(watchState)
+	if err != nil {
+		log.Fatalf("Failed to load watch state: %v", err)
+	}
+
+	if watchList {
+		printSubscriptions(subs, state)
+		return
+	}
+
+	runner := services.NewJobRunner(services.NewClient())
+	if notifier := newNotifier(); notifier != nil {
+		runner.SetNotifier(notifier, warnHookError)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if watchMetricsListen != "" {
+		registry := metrics.NewRegistry()
+		runner.SetMetrics(registry)
+		go serveMetrics(ctx, watchMetricsListen, registry)
+	}
+
+	log.Printf("Watching %d subscription(s), state in %s", len(subs), watchState)
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchSubscription(ctx, runner, state, sub)
+		}()
+	}
+	wg.Wait()
+
+	log.Println("Stopped watching")
+}
+
+// loadSubscriptions validates the configured subscriptions and parses
+// their schedules
+func loadSubscriptions(configured []models.Subscription) ([]subscription, error) {
+	names := make(map[string]bool)
+	var subs []subscription
+
+	for i, sub := range configured {
+		if sub.Name == "" {
+			return nil, fmt.Errorf("subscription %d has no name", i+1)
+		}
+		if names[sub.Name] {
+			return nil, fmt.Errorf("duplicate subscription name %q", sub.Name)
+		}
+		names[sub.Name] = true
+
+		if sub.Tags == "" {
+			return nil, fmt.Errorf("subscription %q has no tags", sub.Name)
+		}
+		if sub.Schedule == "" {
+			return nil, fmt.Errorf("subscription %q has no schedule", sub.Name)
+		}
+		sched, err := schedule.Parse(sub.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %q: %w", sub.Name, err)
+		}
+
+		subs = append(subs, subscription{Subscription: sub, schedule: sched})
+	}
+	return subs, nil
+}
+
+// watchSubscription runs a subscription on its schedule until ctx is canceled
+func watchSubscription(ctx context.Context, runner *services.JobRunner, state *services.WatchState, sub subscription) {
+	for {
+		next := nextRun(sub, state.Get(sub.Name), time.Now())
+
+		jitter := sub.Jitter
+		if jitter == 0 {
+			jitter = watchJitter
+		}
+		if jitter > 0 {
+			next = next.Add(rand.N(jitter))
+		}
+
+		log.Printf("%s: next run at %s", sub.Name, next.Format("2006-01-02 15:04:05"))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		runSubscription(ctx, runner, state, sub)
+	}
+}
+
+// nextRun returns when a subscription is due. Subscriptions that never ran
+// start right away on an interval or at the next match of a cron schedule.
+// Scheduled times that passed while the previous run was going are skipped.
+func nextRun(sub subscription, last models.SubscriptionState, now time.Time) time.Time {
+	if last.LastRun.IsZero() {
+		if _, ok := sub.schedule.(schedule.Interval); ok {
+			return now
+		}
+		return sub.schedule.Next(now)
+	}
+
+	next := sub.schedule.Next(last.LastRun)
+	finished := last.LastRun.Add(last.Duration)
+	if next.Before(finished) {
+		next = sub.schedule.Next(finished)
+	}
+	return next
+}
+
+// runSubscription runs a subscription once and records the outcome
+func runSubscription(ctx context.Context, runner *services.JobRunner, state *services.WatchState, sub subscription) {
+	log.Printf("%s: running %q", sub.Name, sub.Tags)
+
+	started := time.Now()
+	stats, err := runner.RunContext(ctx, sub.Job, nil, nil)
+	duration := time.Since(started)
+
+	if ctx.Err() != nil {
+		log.Printf("%s: interrupted, it runs again on the next start", sub.Name)
+		return
+	}
+
+	skipped := sub.schedule.Next(started).Before(started.Add(duration))
+	if stats == nil {
+		stats = &models.DownloadStats{}
+	}
+
+	if err != nil {
+		log.Printf("%s: failed after %s: %v", sub.Name, utils.FormatDuration(duration), err)
+	} else {
+		log.Printf("%s: done in %s: %d downloaded, %d skipped, %d failed (%d images, %d gifs, %d videos)",
+			sub.Name, utils.FormatDuration(duration),
+			stats.Downloaded, stats.Skipped, stats.Failed,
+			stats.Images, stats.Gifs, stats.Videos)
+	}
+	if skipped {
+		log.Printf("%s: run took longer than its schedule, skipping the overlapped run", sub.Name)
+	}
+
+	updateErr := state.Update(sub.Name, func(s *models.SubscriptionState) {
+		s.LastRun = started
+		s.Duration = duration
+		s.Stats = *stats
+		s.Runs++
+		s.Status = models.JobDone
+		s.Error = ""
+		if err != nil {
+			s.Status = models.JobFailed
+			s.Error = err.Error()
+		}
+		if skipped {
+			s.Skipped++
+		}
+	})
+	if updateErr != nil {
+		log.Printf("Warning: %v", updateErr)
+	}
+}
+
+func printSubscriptions(subs []subscription, state *services.WatchState) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSCHEDULE\tLAST RUN\tSTATUS\tDOWNLOADED\tNEXT RUN")
+
+	now := time.Now()
+	for _, sub := range subs {
+		last := state.Get(sub.Name)
+		lastRun, status := "never", "-"
+		if !last.LastRun.IsZero() {
+			lastRun = last.LastRun.Format("2006-01-02 15:04")
+			status = string(last.Status)
+		}
+
+		next := nextRun(sub, last, now)
+		nextText := next.Format("2006-01-02 15:04")
+		if !next.After(now) {
+			nextText = "now"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			sub.Name, sub.schedule, lastRun, status, last.Stats.Downloaded, nextText)
+	}
+	w.Flush()
+}
+
+// serveMetrics serves the metrics of registry until ctx is canceled
+func serveMetrics(ctx context.Context, addr string, registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("Serving metrics on http://%s/metrics", displayAddr(addr))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Metrics server failed: %v", err)
+	}
+}
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"os"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
+
+	"r34-go/models"
 )
 
 // Settings represents the application configuration
@@ -11,6 +17,101 @@ type Settings struct {
 	Gif    bool   `mapstructure:"gif"`
 	Video  bool   `mapstructure:"video"`
 	IsAPI  bool   `mapstructure:"is_api"`
+
+	// Output is the default output directory
+	Output string `mapstructure:"output"`
+	// Workers is the number of jobs run at the same time by batch and daemon
+	Workers int `mapstructure:"workers"`
+	// Filters are the default client-side filters of downloads
+	Filters models.Filter `mapstructure:"filters"`
+	// Credentials authenticate API requests
+	Credentials CredentialSettings `mapstructure:"credentials"`
+
+	// StoreDir enables the shared content store when set
+	StoreDir string `mapstructure:"store_dir"`
+	LinkMode string `mapstructure:"link_mode"`
+
+	// PHash enables near-duplicate detection for images, PHashAction is
+	// either "flag" or "skip"
+	PHash         bool   `mapstructure:"phash"`
+	PHashDistance int    `mapstructure:"phash_distance"`
+	PHashAction   string `mapstructure:"phash_action"`
+
+	Views ViewSettings `mapstructure:"views"`
+
+	// FilenameTemplate names saved files, e.g. "{artist}_{id}"
+	FilenameTemplate string `mapstructure:"filename_template"`
+	// Sidecar writes a <file>.json with the post metadata next to each file
+	Sidecar bool `mapstructure:"sidecar"`
+	// TagCategories resolves artist/character/copyright tags for every post
+	TagCategories bool `mapstructure:"tag_categories"`
+
+	// Cache stores API list responses and HTML pages on disk
+	Cache CacheSettings `mapstructure:"cache"`
+
+	// Archive bundles the files of every download job into archives
+	Archive ArchiveSettings `mapstructure:"archive"`
+
+	// Hooks are notified when a download job finishes
+	Hooks []HookSettings `mapstructure:"hooks"`
+
+	// Subscriptions are the scheduled jobs run by the watch command
+	Subscriptions []models.Subscription `mapstructure:"subscriptions"`
+}
+
+// HookSettings describes a notification hook. A hook either posts to URL or
+// runs Command, with the job report in its environment and on stdin.
+type HookSettings struct {
+	URL     string `mapstructure:"url" yaml:"url,omitempty"`
+	Command string `mapstructure:"command" yaml:"command,omitempty"`
+	// Format of the posted payload: json, discord or ntfy
+	Format string `mapstructure:"format" yaml:"format,omitempty"`
+	// On lists the outcomes that fire the hook, done and/or failed;
+	// empty means both
+	On []string `mapstructure:"on" yaml:"on,omitempty"`
+}
+
+// CredentialSettings holds the API credentials from the account options page
+type CredentialSettings struct {
+	UserID string `mapstructure:"user_id" yaml:"user_id,omitempty"`
+	APIKey string `mapstructure:"api_key" yaml:"api_key,omitempty"`
+}
+
+// CacheSettings controls the on-disk response cache
+type CacheSettings struct {
+	Enabled bool          `mapstructure:"enabled"`
+	TTL     time.Duration `mapstructure:"ttl"`
+	// MaxSizeMB limits the size of the cache, the oldest responses are
+	// removed first
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+}
+
+// ArchiveSettings controls the archives written by download jobs
+type ArchiveSettings struct {
+	// Format is zip, tar.gz or cbz, or none to keep loose files only
+	Format string `mapstructure:"format"`
+	// Split is the largest size of an archive part, e.g. 500MB; empty
+	// writes a single archive
+	Split string `mapstructure:"split"`
+	// KeepFiles keeps the downloaded files next to the archive
+	KeepFiles bool `mapstructure:"keep_files"`
+}
+
+// ViewSettings controls the tag-based symlink views of an output directory
+type ViewSettings struct {
+	// Auto updates the views after every download
+	Auto     bool `mapstructure:"auto"`
+	ByTag    bool `mapstructure:"by_tag"`
+	ByArtist bool `mapstructure:"by_artist"`
+	ByRating bool `mapstructure:"by_rating"`
+
+	// Tags limits by-tag views to these tags; when empty every tag used by
+	// at least MinCount posts gets a view
+	Tags     []string `mapstructure:"tags"`
+	MinCount int      `mapstructure:"min_count"`
+
+	// Artists lists tags that are treated as artists
+	Artists []string `mapstructure:"artists"`
 }
 
 var AppSettings Settings
@@ -18,34 +119,31 @@ var AppSettings Settings
 // Init initializes the configuration with default values
 func Init() {
 	// Set default values
-	viper.SetDefault("limit", 100)
-	viper.SetDefault("images", true)
-	viper.SetDefault("gif", true)
-	viper.SetDefault("video", true)
-	viper.SetDefault("is_api", true)
-
-	// Set config file properties
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("$HOME/.r34downloader")
-
-	// Try to read config file
-	if err := viper.ReadInConfig(); err != nil {
-		// If config file doesn't exist, create one with defaults
-		viper.SafeWriteConfig()
+	for _, setting := range schema {
+		viper.SetDefault(setting.Key, setting.Default)
 	}
 
+	// Move files left by older versions to the XDG directories
+	migrateLegacy()
+
+	// Set config file properties. R34_CONFIG points to another file.
+	if file := os.Getenv(envPrefix + "_CONFIG"); file != "" {
+		viper.SetConfigFile(file)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath(ConfigDir())
+	}
+
+	// A missing config file leaves the defaults, config init writes one
+	viper.ReadInConfig()
+
+	// R34_* environment variables override the config file, e.g.
+	// R34_LIMIT or R34_FILTERS_MIN_SCORE
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
 	// Unmarshal config into AppSettings
 	viper.Unmarshal(&AppSettings)
 }
-
-// Save saves the current settings to config file
-func Save() error {
-	viper.Set("limit", AppSettings.Limit)
-	viper.Set("images", AppSettings.Images)
-	viper.Set("gif", AppSettings.Gif)
-	viper.Set("video", AppSettings.Video)
-	viper.Set("is_api", AppSettings.IsAPI)
-	return viper.WriteConfig()
-}
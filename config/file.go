@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// File returns the path of the config file in use
+func File() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return file
+	}
+	return filepath.Join(ConfigDir(), configFile)
+}
+
+// SetValue writes a setting to the config file at path, keeping the rest
+// of the file and its comments. Keys under profiles.<name>. set the value
+// in that profile.
+func SetValue(path, key string, value any) error {
+	doc, err := loadDocument(path)
+	if err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	if node.Kind == yaml.SequenceNode {
+		node.Style = yaml.FlowStyle
+	}
+
+	setNode(doc.Content[0], strings.Split(key, "."), &node)
+	return saveDocument(path, doc)
+}
+
+// UnsetValue removes a setting from the config file at path so that it
+// falls back to its default, and reports whether it was set
+func UnsetValue(path, key string) (bool, error) {
+	doc, err := loadDocument(path)
+	if err != nil {
+		return false, err
+	}
+	if !unsetNode(doc.Content[0], strings.Split(key, ".")) {
+		return false, nil
+	}
+	return true, saveDocument(path, doc)
+}
+
+// ReadValues parses the config file at path
+func ReadValues(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Template returns a config file with every setting at its default value,
+// each described by a comment
+func Template() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, setting := range schema {
+		parent := root
+		name := setting.Key
+		if section, child, ok := strings.Cut(setting.Key, "."); ok {
+			parent = findNode(root, section)
+			if parent == nil {
+				parent = &yaml.Node{Kind: yaml.MappingNode}
+				key := &yaml.Node{Kind: yaml.ScalarNode, Value: section, HeadComment: sections[section]}
+				root.Content = append(root.Content, key, parent)
+			}
+			name = child
+		}
+
+		var value yaml.Node
+		if err := value.Encode(setting.Default); err != nil {
+			return nil, err
+		}
+		if value.Kind == yaml.SequenceNode {
+			value.Style = yaml.FlowStyle
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: name, HeadComment: setting.Description}
+		parent.Content = append(parent.Content, key, &value)
+	}
+
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	doc.HeadComment = "r34-go configuration, see r34-go config --help"
+	return encodeDocument(doc)
+}
+
+// loadDocument reads the config file at path as a YAML node tree. A missing
+// or empty file gives an empty mapping.
+func loadDocument(path string) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s does not contain a mapping of settings", path)
+	}
+	return doc, nil
+}
+
+// saveDocument writes a YAML node tree to path, replacing the file
+// atomically
+func saveDocument(path string, doc *yaml.Node) error {
+	data, err := encodeDocument(doc)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save config file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func encodeDocument(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// findNode returns the value of name in a mapping node
+func findNode(mapping *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, name) {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setNode sets the value at path below a mapping node, creating the
+// mappings in between
+func setNode(mapping *yaml.Node, path []string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if !strings.EqualFold(mapping.Content[i].Value, path[0]) {
+			continue
+		}
+		if len(path) == 1 {
+			mapping.Content[i+1] = value
+			return
+		}
+		child := mapping.Content[i+1]
+		if child.Kind != yaml.MappingNode {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			mapping.Content[i+1] = child
+		}
+		setNode(child, path[1:], value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, key, value)
+		return
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, key, child)
+	setNode(child, path[1:], value)
+}
+
+// unsetNode removes the value at path below a mapping node, dropping
+// mappings left empty, and reports whether it was found
+func unsetNode(mapping *yaml.Node, path []string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if !strings.EqualFold(mapping.Content[i].Value, path[0]) {
+			continue
+		}
+		if len(path) > 1 {
+			child := mapping.Content[i+1]
+			if child.Kind != yaml.MappingNode || !unsetNode(child, path[1:]) {
+				return false
+			}
+			if len(child.Content) > 0 {
+				return true
+			}
+		}
+		mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+		return true
+	}
+	return false
+}
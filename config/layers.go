@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix prefixes the environment variables overriding settings
+const envPrefix = "R34"
+
+var (
+	// activeProfile is the profile merged over the config file
+	activeProfile string
+	// profileKeys are the keys set by the active profile
+	profileKeys map[string]bool
+	// flagKeys maps keys set on the command line to their flag
+	flagKeys = make(map[string]string)
+)
+
+// Profiles returns the names of the profiles in the config file
+func Profiles() []string {
+	var names []string
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ActiveProfile returns the name of the profile in use, if any
+func ActiveProfile() string {
+	return activeProfile
+}
+
+// UseProfile merges a profile from the profiles section of the config file
+// over the file's own values. Environment variables and flags still
+// override the profile.
+func UseProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	profile, ok := viper.Get("profiles." + name).(map[string]any)
+	if !ok {
+		available := "none"
+		if names := Profiles(); len(names) > 0 {
+			available = strings.Join(names, ", ")
+		}
+		return fmt.Errorf("profile %q not found (available: %s)", name, available)
+	}
+
+	if err := viper.MergeConfigMap(profile); err != nil {
+		return fmt.Errorf("failed to apply profile %q: %w", name, err)
+	}
+	activeProfile = name
+	profileKeys = make(map[string]bool)
+	collectKeys(profile, "", profileKeys)
+	return nil
+}
+
+// BindFlag lets a flag override a setting when it is given on the command
+// line
+func BindFlag(key string, flag *pflag.Flag) {
+	if !flag.Changed {
+		return
+	}
+	viper.BindPFlag(key, flag)
+	flagKeys[key] = flag.Name
+}
+
+// SetFromFlag overrides a setting with a value derived from a flag, such as
+// the inverse --no-images
+func SetFromFlag(key string, value any, flagName string) {
+	viper.Set(key, value)
+	flagKeys[key] = flagName
+}
+
+// Reload applies the configuration layers to AppSettings
+func Reload() error {
+	return viper.Unmarshal(&AppSettings)
+}
+
+// Keys returns every setting key, sorted. Profiles are left out.
+func Keys() []string {
+	var keys []string
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, "profiles.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Source describes where the effective value of a key comes from, in
+// order of precedence: flag, environment, profile, config file, default
+func Source(key string) string {
+	if flag, ok := flagKeys[key]; ok {
+		return "flag --" + flag
+	}
+	if env := EnvVar(key); os.Getenv(env) != "" {
+		return "env " + env
+	}
+	if profileKeys[key] {
+		return "profile " + activeProfile
+	}
+	if viper.InConfig(key) {
+		return "config file " + viper.ConfigFileUsed()
+	}
+	return "default"
+}
+
+// EnvVar returns the environment variable overriding a key
+func EnvVar(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// collectKeys adds the dotted keys of a nested map to keys
+func collectKeys(m map[string]any, prefix string, keys map[string]bool) {
+	for k, v := range m {
+		key := prefix + strings.ToLower(k)
+		if nested, ok := v.(map[string]any); ok {
+			collectKeys(nested, key+".", keys)
+			continue
+		}
+		keys[key] = true
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	appName    = "r34-go"
+	configFile = "config.yaml"
+	// legacyDirName is the directory used for everything before the XDG
+	// directories
+	legacyDirName = ".r34downloader"
+)
+
+// legacyFiles maps the files of the legacy directory to the directory they
+// belong in now
+var legacyFiles = map[string]func() string{
+	configFile:           ConfigDir,
+	"tag_cache.json":     CacheDir,
+	"query_history.json": DataDir,
+	"queue.json":         DataDir,
+	"watch_state.json":   DataDir,
+}
+
+var (
+	// migrated records the files moved by the last migration
+	migrated []string
+	// migrateErr holds the files that could not be moved
+	migrateErr error
+)
+
+// ConfigDir returns the directory of the config file:
+// $XDG_CONFIG_HOME/r34-go, or the platform's config directory
+func ConfigDir() string {
+	return appDir("XDG_CONFIG_HOME", os.UserConfigDir)
+}
+
+// CacheDir returns the directory for data that can be fetched again, such
+// as tag data and API responses: $XDG_CACHE_HOME/r34-go, or the platform's
+// cache directory
+func CacheDir() string {
+	return appDir("XDG_CACHE_HOME", os.UserCacheDir)
+}
+
+// DataDir returns the directory for state such as the job queue and the
+// subscription runs: $XDG_DATA_HOME/r34-go, or the platform's data
+// directory
+func DataDir() string {
+	return appDir("XDG_DATA_HOME", userDataDir)
+}
+
+// Migrated returns the legacy files moved to the XDG directories on this
+// start, and the files that failed to move
+func Migrated() ([]string, error) {
+	return migrated, migrateErr
+}
+
+// appDir returns the application directory below the directory named by
+// an XDG variable, falling back to the platform default. It exits when
+// neither is known rather than scattering state below the working
+// directory.
+func appDir(env string, platformDir func() (string, error)) string {
+	// The spec says relative paths are invalid and must be ignored
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName)
+	}
+	dir, err := platformDir()
+	if err != nil {
+		log.Fatalf("Error: cannot find a directory for %s files: %v (set %s to an absolute path)", appName, err, env)
+	}
+	return filepath.Join(dir, appName)
+}
+
+// userDataDir is the data counterpart of os.UserConfigDir
+func userDataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("%%LocalAppData%% is not defined")
+	case "darwin", "ios":
+		return os.UserConfigDir()
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// legacyDir returns the directory used before the XDG directories, or ""
+// when there is no home directory to hold one
+func legacyDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, legacyDirName)
+}
+
+// migrateLegacy moves the files of the legacy directory to the XDG
+// directories and removes it once empty. A config.yaml in the working
+// directory, where older versions created it, is copied when there is no
+// config file yet.
+func migrateLegacy() {
+	migrated, migrateErr = nil, nil
+	legacy := legacyDir()
+
+	for name, dir := range legacyFiles {
+		if legacy == "" {
+			break // No home directory, so nothing to migrate
+		}
+		from := filepath.Join(legacy, name)
+		to := filepath.Join(dir(), name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			continue // Never overwrite newer files
+		}
+		if err := moveFile(from, to); err != nil {
+			migrateErr = errors.Join(migrateErr, fmt.Errorf("failed to move %s to %s: %w", from, to, err))
+			continue
+		}
+		migrated = append(migrated, fmt.Sprintf("%s -> %s", from, to))
+	}
+	if legacy != "" {
+		os.Remove(legacy) // Only succeeds when nothing else is left
+	}
+
+	to := filepath.Join(ConfigDir(), configFile)
+	if _, err := os.Stat(to); !os.IsNotExist(err) || !isOwnConfig(configFile) {
+		return
+	}
+	if err := copyFile(configFile, to); err != nil {
+		migrateErr = errors.Join(migrateErr, fmt.Errorf("failed to copy %s to %s: %w", configFile, to, err))
+		return
+	}
+	migrated = append(migrated, fmt.Sprintf("%s -> %s (copied, the original can be deleted)", configFile, to))
+}
+
+// isOwnConfig checks that a file is a valid config file of this program
+// rather than the config.yaml of something else
+func isOwnConfig(path string) bool {
+	values, err := ReadValues(path)
+	return err == nil && len(values) > 0 && len(ValidateValues(values)) == 0
+}
+
+// moveFile renames a file, copying it when the directories are on
+// different devices
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	if err := copyFile(from, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+func copyFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	return dst.Close()
+}
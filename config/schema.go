@@ -0,0 +1,394 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+// Kind is the type of a setting value
+type Kind string
+
+// Setting kinds
+const (
+	KindString Kind = "string"
+	KindInt    Kind = "int"
+	KindBool   Kind = "bool"
+	KindList   Kind = "list"
+	// KindDuration is a duration such as 30m or 6h
+	KindDuration Kind = "duration"
+	// KindSize is a size such as 500MB or 2G, empty for none
+	KindSize Kind = "size"
+	// KindObjects is a list of mappings, such as hooks, which is only
+	// changed by editing the config file
+	KindObjects Kind = "objects"
+)
+
+// Setting describes a configuration key, its default and the values it
+// accepts
+type Setting struct {
+	Key         string
+	Kind        Kind
+	Default     any
+	Description string
+
+	// Min and Max bound int values when Max is set
+	Min, Max int
+	// Values lists the accepted values of strings and list items, compared
+	// case-insensitively
+	Values []string
+	// Requires lists substrings of which string values need at least one
+	Requires []string
+}
+
+// schema lists every setting in the order config init writes them
+var schema = []Setting{
+	{Key: "limit", Kind: KindInt, Default: 100, Min: 1, Max: 65535, Description: "Number of posts downloaded by default"},
+	{Key: "images", Kind: KindBool, Default: true, Description: "Download images"},
+	{Key: "gif", Kind: KindBool, Default: true, Description: "Download GIFs"},
+	{Key: "video", Kind: KindBool, Default: true, Description: "Download videos"},
+	{Key: "is_api", Kind: KindBool, Default: true, Description: "Use the API instead of HTML parsing"},
+	{Key: "output", Kind: KindString, Default: "./downloads", Description: "Default output directory"},
+	{Key: "workers", Kind: KindInt, Default: 1, Min: 1, Max: 64, Description: "Jobs run at the same time by batch and daemon"},
+	{Key: "filters.min_score", Kind: KindInt, Default: 0, Min: -100000, Max: 1000000, Description: "Skip posts scored below this"},
+	{Key: "filters.ratings", Kind: KindList, Default: []string{}, Values: []string{"s", "q", "e", "safe", "questionable", "explicit"}, Description: "Keep only these ratings"},
+	{Key: "filters.blacklist", Kind: KindList, Default: []string{}, Description: "Skip posts with any of these tags"},
+	{Key: "filters.artists", Kind: KindList, Default: []string{}, Description: "Keep only posts by one of these artists"},
+	{Key: "filters.characters", Kind: KindList, Default: []string{}, Description: "Keep only posts with one of these characters"},
+	{Key: "filters.copyrights", Kind: KindList, Default: []string{}, Description: "Keep only posts from one of these copyrights"},
+	{Key: "credentials.user_id", Kind: KindString, Default: "", Description: "API user ID from the account options page"},
+	{Key: "credentials.api_key", Kind: KindString, Default: "", Description: "API key from the account options page"},
+	{Key: "store_dir", Kind: KindString, Default: "", Description: "Shared content store directory, empty to disable"},
+	{Key: "link_mode", Kind: KindString, Default: "auto", Values: []string{"auto", "hardlink", "symlink", "reflink"}, Description: "How files are linked from the store"},
+	{Key: "phash", Kind: KindBool, Default: false, Description: "Detect near-duplicate images"},
+	{Key: "phash_distance", Kind: KindInt, Default: 5, Min: 0, Max: 64, Description: "Largest hash distance counted as a near-duplicate"},
+	{Key: "phash_action", Kind: KindString, Default: "flag", Values: []string{"flag", "skip"}, Description: "What to do with near-duplicates"},
+	{Key: "views.auto", Kind: KindBool, Default: false, Description: "Update the views after every download"},
+	{Key: "views.by_tag", Kind: KindBool, Default: true, Description: "Build by-tag views"},
+	{Key: "views.by_artist", Kind: KindBool, Default: true, Description: "Build by-artist views"},
+	{Key: "views.by_rating", Kind: KindBool, Default: true, Description: "Build by-rating views"},
+	{Key: "views.tags", Kind: KindList, Default: []string{}, Description: "Tags that get a by-tag view, empty for all"},
+	{Key: "views.min_count", Kind: KindInt, Default: 1, Min: 1, Max: 1000000, Description: "Posts a tag needs for its own view"},
+	{Key: "views.artists", Kind: KindList, Default: []string{}, Description: "Tags treated as artists"},
+	{Key: "filename_template", Kind: KindString, Default: "{id}", Requires: []string{"{id}", "{md5}"}, Description: "File name template, e.g. {artist}_{id}"},
+	{Key: "sidecar", Kind: KindBool, Default: false, Description: "Write a <file>.json with the post metadata"},
+	{Key: "tag_categories", Kind: KindBool, Default: false, Description: "Resolve tag categories for every post"},
+	{Key: "cache.enabled", Kind: KindBool, Default: false, Description: "Cache API list responses and HTML pages on disk"},
+	{Key: "cache.ttl", Kind: KindDuration, Default: "1h", Description: "How long cached responses are used"},
+	{Key: "cache.max_size_mb", Kind: KindInt, Default: 100, Min: 1, Max: 100000, Description: "Size limit of the cache in megabytes"},
+	{Key: "archive.format", Kind: KindString, Default: "none", Values: []string{"none", "zip", "tar.gz", "cbz"}, Description: "Bundle the files of every download job into an archive"},
+	{Key: "archive.split", Kind: KindSize, Default: "", Description: "Largest size of an archive part, e.g. 500MB, empty for one archive"},
+	{Key: "archive.keep_files", Kind: KindBool, Default: true, Description: "Keep the downloaded files next to the archive, otherwise downloads are streamed straight into it"},
+	{Key: "hooks", Kind: KindObjects, Default: []HookSettings{}, Description: "Notification hooks, see r34-go hooks --help"},
+	{Key: "subscriptions", Kind: KindObjects, Default: []models.Subscription{}, Description: "Scheduled jobs, see r34-go watch --help"},
+}
+
+// sections describes the groups of nested settings
+var sections = map[string]string{
+	"filters":     "Client-side filters applied to every download",
+	"credentials": "API credentials",
+	"views":       "Tag-based symlink views of the output directory",
+	"cache":       "On-disk cache of API and HTML pages, media files are never cached",
+	"archive":     "Zip, tar.gz or CBZ archives of downloaded files",
+}
+
+// objectFields lists the fields accepted by the entries of object lists
+var objectFields = map[string][]string{
+	"hooks":         {"url", "command", "format", "on"},
+	"subscriptions": {"name", "tags", "quantity", "output", "images", "gifs", "videos", "source", "filters", "schedule", "jitter"},
+}
+
+// Schema returns every setting
+func Schema() []Setting {
+	return schema
+}
+
+// Lookup returns the setting of a key
+func Lookup(key string) (Setting, error) {
+	key = strings.ToLower(key)
+	for _, s := range schema {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+
+	if _, ok := sections[key]; ok {
+		return Setting{}, fmt.Errorf("%s is a section, use one of its keys: %s", key, strings.Join(sectionKeys(key), ", "))
+	}
+	if suggestion := closestKey(key); suggestion != "" {
+		return Setting{}, fmt.Errorf("unknown key %q (did you mean %s?)", key, suggestion)
+	}
+	return Setting{}, fmt.Errorf("unknown key %q", key)
+}
+
+// Parse converts a command-line value to the type of the setting and
+// validates it. List values are separated by commas.
+func (s Setting) Parse(value string) (any, error) {
+	switch s.Kind {
+	case KindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", s.Key, value)
+		}
+		return b, nil
+	case KindInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number, got %q", s.Key, value)
+		}
+		return n, s.Validate(n)
+	case KindList:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, s.Validate(items)
+	case KindObjects:
+		return nil, fmt.Errorf("%s is a list of entries, change it with config edit", s.Key)
+	default:
+		return value, s.Validate(value)
+	}
+}
+
+// Validate checks a value read from a config file against the setting
+func (s Setting) Validate(value any) error {
+	switch s.Kind {
+	case KindBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be true or false, got %v", s.Key, value)
+		}
+	case KindInt:
+		n, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("%s must be a whole number, got %v", s.Key, value)
+		}
+		if s.Max != 0 && (n < s.Min || n > s.Max) {
+			return fmt.Errorf("%s must be between %d and %d, got %d", s.Key, s.Min, s.Max, n)
+		}
+	case KindString:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string, got %v", s.Key, value)
+		}
+		return s.checkValue(str)
+	case KindList:
+		var items []string
+		switch v := value.(type) {
+		case []string:
+			items = v
+		case []any:
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("%s must be a list of strings, got item %v", s.Key, item)
+				}
+				items = append(items, str)
+			}
+		default:
+			return fmt.Errorf("%s must be a list, got %v", s.Key, value)
+		}
+		for _, item := range items {
+			if err := s.checkValue(item); err != nil {
+				return err
+			}
+		}
+	case KindDuration:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a duration such as 30m or 6h, got %v", s.Key, value)
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s must be a positive duration such as 30m or 6h, got %q", s.Key, str)
+		}
+	case KindSize:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a size such as 500MB or 2G, got %v", s.Key, value)
+		}
+		if str != "" {
+			if _, err := utils.ParseFileSize(str); err != nil {
+				return fmt.Errorf("%s must be a size such as 500MB or 2G, got %q", s.Key, str)
+			}
+		}
+	case KindObjects:
+		return validateObjects(s.Key, value)
+	}
+	return nil
+}
+
+// checkValue checks a string against the accepted values of the setting
+func (s Setting) checkValue(value string) error {
+	if len(s.Requires) > 0 && !containsAny(value, s.Requires) {
+		return fmt.Errorf("%s must contain %s, got %q", s.Key, strings.Join(s.Requires, " or "), value)
+	}
+	if len(s.Values) == 0 {
+		return nil
+	}
+	for _, allowed := range s.Values {
+		if strings.EqualFold(value, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q (expected one of %s)", s.Key, value, strings.Join(s.Values, ", "))
+}
+
+func containsAny(value string, parts []string) bool {
+	for _, part := range parts {
+		if strings.Contains(value, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateObjects checks that a value is a list of mappings with known
+// fields
+func validateObjects(key string, value any) error {
+	entries, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("%s must be a list, got %v", key, value)
+	}
+	for i, entry := range entries {
+		fields, ok := entry.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s entry %d must be a mapping", key, i+1)
+		}
+		for field := range fields {
+			if !contains(objectFields[key], field) {
+				return fmt.Errorf("%s entry %d has unknown field %q (expected %s)", key, i+1, field, strings.Join(objectFields[key], ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateValues checks the settings of a parsed config file, including
+// the settings of its profiles, and returns one error per problem
+func ValidateValues(values map[string]any) []error {
+	var errs []error
+	for _, name := range sortedKeys(values) {
+		if name != "profiles" {
+			errs = append(errs, validateTree(values, name, "")...)
+			continue
+		}
+
+		profiles, ok := values[name].(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("profiles must be a mapping of profile names"))
+			continue
+		}
+		for _, profile := range sortedKeys(profiles) {
+			settings, ok := profiles[profile].(map[string]any)
+			if !ok {
+				errs = append(errs, fmt.Errorf("profile %q must be a mapping of settings", profile))
+				continue
+			}
+			for _, key := range sortedKeys(settings) {
+				for _, err := range validateTree(settings, key, "") {
+					errs = append(errs, fmt.Errorf("profile %s: %w", profile, err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateTree validates the value of key in m, descending into sections
+func validateTree(m map[string]any, name, prefix string) []error {
+	key := prefix + strings.ToLower(name)
+	value := m[name]
+
+	if _, ok := sections[key]; ok {
+		nested, ok := value.(map[string]any)
+		if !ok {
+			return []error{fmt.Errorf("%s must be a mapping", key)}
+		}
+		var errs []error
+		for _, child := range sortedKeys(nested) {
+			errs = append(errs, validateTree(nested, child, key+".")...)
+		}
+		return errs
+	}
+
+	setting, err := Lookup(key)
+	if err != nil {
+		return []error{err}
+	}
+	if err := setting.Validate(value); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// sectionKeys returns the keys of a section
+func sectionKeys(section string) []string {
+	var keys []string
+	for _, s := range schema {
+		if strings.HasPrefix(s.Key, section+".") {
+			keys = append(keys, s.Key)
+		}
+	}
+	return keys
+}
+
+// closestKey suggests the known key nearest to a mistyped one
+func closestKey(key string) string {
+	best, bestDistance := "", 4
+	for _, s := range schema {
+		name := s.Key
+		if _, after, ok := strings.Cut(s.Key, "."); ok && !strings.Contains(key, ".") {
+			name = after
+		}
+		if d := editDistance(key, name); d < bestDistance {
+			best, bestDistance = s.Key, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
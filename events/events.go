@@ -0,0 +1,85 @@
+// Package events provides sinks that deliver download progress events.
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"r34-go/models"
+)
+
+// Multi publishes every event to several sinks
+type Multi []models.EventSink
+
+// Publish sends the event to each sink in order
+func (m Multi) Publish(event models.Event) {
+	for _, sink := range m {
+		sink.Publish(event)
+	}
+}
+
+// Func adapts a function to an event sink
+type Func func(event models.Event)
+
+// Publish calls the function
+func (f Func) Publish(event models.Event) {
+	f(event)
+}
+
+// WithJob returns a sink tagging every event with a job ID before passing it on
+func WithJob(sink models.EventSink, job string) models.EventSink {
+	return Func(func(event models.Event) {
+		event.Job = job
+		sink.Publish(event)
+	})
+}
+
+// JSONLines writes events as one JSON object per line
+type JSONLines struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLines creates a sink writing JSON lines to w
+func NewJSONLines(w io.Writer) *JSONLines {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &JSONLines{encoder: encoder}
+}
+
+// Publish writes the event as a single line
+func (j *JSONLines) Publish(event models.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encoder.Encode(event)
+}
+
+// FileList collects the paths of downloaded files
+type FileList struct {
+	mu    sync.Mutex
+	files []string
+}
+
+// Publish records the file of a file_done event
+func (l *FileList) Publish(event models.Event) {
+	if event.Type != models.EventFileDone || event.File == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, event.File)
+}
+
+// Files returns the collected paths in download order
+func (l *FileList) Files() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.files...)
+}
@@ -0,0 +1,228 @@
+// Package metrics collects download metrics and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Labels identify the run a metric belongs to. Job is exported as the
+// job_name label since Prometheus reserves job for the scrape target.
+type Labels struct {
+	Source string
+	Job    string
+}
+
+var (
+	pageBuckets     = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	downloadBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+)
+
+// Registry holds the metrics of a process. A nil registry records nothing.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates a registry with every metric at zero
+func NewRegistry() *Registry {
+	r := &Registry{families: make(map[string]*family)}
+	r.define("r34_posts_total", "Posts handled by result (downloaded, skipped or failed).", nil, "result")
+	r.define("r34_files_total", "Files downloaded by type (image, gif or video).", nil, "type")
+	r.define("r34_bytes_total", "Bytes received over HTTP.", nil)
+	r.define("r34_http_requests_total", "HTTP requests by host and status code.", nil, "host", "code")
+	r.define("r34_retries_total", "Download attempts that were retried.", nil)
+	r.define("r34_page_fetch_duration_seconds", "Time to fetch and read an API or HTML page.", pageBuckets)
+	r.define("r34_download_duration_seconds", "Time to download a file.", downloadBuckets)
+	return r
+}
+
+// Post counts a handled post
+func (r *Registry) Post(labels Labels, result string) {
+	r.add("r34_posts_total", labels, 1, result)
+}
+
+// File counts a downloaded file
+func (r *Registry) File(labels Labels, fileType string) {
+	r.add("r34_files_total", labels, 1, fileType)
+}
+
+// Bytes counts received bytes
+func (r *Registry) Bytes(labels Labels, n int) {
+	r.add("r34_bytes_total", labels, float64(n))
+}
+
+// Request counts an HTTP request; code is "error" when no response arrived
+func (r *Registry) Request(labels Labels, host, code string) {
+	r.add("r34_http_requests_total", labels, 1, host, code)
+}
+
+// Retry counts a retried download
+func (r *Registry) Retry(labels Labels) {
+	r.add("r34_retries_total", labels, 1)
+}
+
+// PageFetch records the latency of a page request
+func (r *Registry) PageFetch(labels Labels, d time.Duration) {
+	r.observe("r34_page_fetch_duration_seconds", labels, d.Seconds())
+}
+
+// Download records the latency of a file download
+func (r *Registry) Download(labels Labels, d time.Duration) {
+	r.observe("r34_download_duration_seconds", labels, d.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// WriteText writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		r.families[name].write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r *Registry) define(name, help string, buckets []float64, labels ...string) {
+	r.families[name] = &family{
+		name:    name,
+		help:    help,
+		labels:  append([]string{"source", "job_name"}, labels...),
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+func (r *Registry) add(name string, labels Labels, v float64, values ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[name].get(labels, values).value += v
+}
+
+func (r *Registry) observe(name string, labels Labels, v float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.families[name]
+	s := f.get(labels, nil)
+	for i, bound := range f.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+// family is a metric with all its label combinations. Families with
+// buckets are histograms, the others counters.
+type family struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is a single label combination of a family
+type series struct {
+	values []string
+	// value is the counter value or the histogram sum
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func (f *family) get(labels Labels, values []string) *series {
+	values = append([]string{labels.Source, labels.Job}, values...)
+	key := strings.Join(values, "\xff")
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(b *strings.Builder) {
+	kind := "counter"
+	if f.buckets != nil {
+		kind = "histogram"
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.labelPairs(s.values)
+		if f.buckets == nil {
+			fmt.Fprintf(b, "%s{%s} %s\n", f.name, labels, formatValue(s.value))
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", f.name, labels, formatValue(bound), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", f.name, labels, s.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", f.name, labels, formatValue(s.value))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", f.name, labels, s.count)
+	}
+}
+
+func (f *family) labelPairs(values []string) string {
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeLabel(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// EventType identifies what happened during a download run
+type EventType string
+
+// Event types
+const (
+	EventPageFetched  EventType = "page_fetched"
+	EventPostSkipped  EventType = "post_skipped"
+	EventFileStarted  EventType = "file_started"
+	EventBytesWritten EventType = "bytes_written"
+	EventFileDone     EventType = "file_done"
+	EventFileFailed   EventType = "file_failed"
+	EventRunFinished  EventType = "run_finished"
+	EventJobStarted   EventType = "job_started"
+	EventJobFinished  EventType = "job_finished"
+)
+
+// Event is a progress event of a download run. Only the fields that apply
+// to the event type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Job is the ID of the daemon job the event belongs to
+	Job string `json:"job,omitempty"`
+
+	// Page is the page number of a fetched page and Posts its post count
+	Page  int `json:"page,omitempty"`
+	Posts int `json:"posts,omitempty"`
+
+	PostID string `json:"post_id,omitempty"`
+	URL    string `json:"url,omitempty"`
+	File   string `json:"file,omitempty"`
+
+	// Bytes is the number of bytes written so far and Size the expected
+	// size of the file, when known
+	Bytes int64 `json:"bytes,omitempty"`
+	Size  int64 `json:"size,omitempty"`
+
+	// Reason tells why a post was skipped or a file failed
+	Reason string `json:"reason,omitempty"`
+
+	// Current and Total give the progress of the run in posts
+	Current int `json:"current,omitempty"`
+	Total   int `json:"total,omitempty"`
+
+	// Stats is set on events ending a run or job
+	Stats *DownloadStats `json:"stats,omitempty"`
+}
+
+// EventSink receives progress events. Sinks may be called from several
+// goroutines at once.
+type EventSink interface {
+	Publish(event Event)
+}
@@ -0,0 +1,90 @@
+package models
+
+import "strings"
+
+// Filter holds client-side rules deciding which posts are kept
+type Filter struct {
+	MinScore  int      `mapstructure:"min_score" json:"min_score,omitempty"`
+	Ratings   []string `mapstructure:"ratings" json:"ratings,omitempty"`
+	Blacklist []string `mapstructure:"blacklist" json:"blacklist,omitempty"`
+
+	// Posts must have at least one of the listed tags in each category
+	Artists    []string `mapstructure:"artists" json:"artists,omitempty"`
+	Characters []string `mapstructure:"characters" json:"characters,omitempty"`
+	Copyrights []string `mapstructure:"copyrights" json:"copyrights,omitempty"`
+}
+
+// IsEmpty reports whether the filter keeps every post
+func (f Filter) IsEmpty() bool {
+	return f.MinScore == 0 && len(f.Ratings) == 0 && len(f.Blacklist) == 0 && !f.UsesCategories()
+}
+
+// UsesCategories reports whether the filter needs resolved tag categories
+func (f Filter) UsesCategories() bool {
+	return len(f.Artists) > 0 || len(f.Characters) > 0 || len(f.Copyrights) > 0
+}
+
+// Match reports whether a post passes the filter
+func (f Filter) Match(post Post) bool {
+	if post.Score < f.MinScore {
+		return false
+	}
+
+	if len(f.Ratings) > 0 && !matchesRating(post.Rating, f.Ratings) {
+		return false
+	}
+
+	if len(f.Blacklist) > 0 {
+		for _, tag := range strings.Fields(post.Tags) {
+			for _, blocked := range f.Blacklist {
+				if strings.EqualFold(tag, blocked) {
+					return false
+				}
+			}
+		}
+	}
+
+	if f.UsesCategories() {
+		// Without resolved categories fall back to matching any tag
+		groups := TagGroups{Artist: post.TagList(), Character: post.TagList(), Copyright: post.TagList()}
+		if post.Groups != nil {
+			groups = *post.Groups
+		}
+		if !matchesAny(groups.Artist, f.Artists) ||
+			!matchesAny(groups.Character, f.Characters) ||
+			!matchesAny(groups.Copyright, f.Copyrights) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesAny reports whether tags contain one of wanted, or wanted is empty
+func matchesAny(tags, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if strings.EqualFold(tag, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesRating compares ratings by their first letter so that "s",
+// "safe" and "Safe" are treated the same
+func matchesRating(rating string, ratings []string) bool {
+	if rating == "" {
+		return false
+	}
+	for _, r := range ratings {
+		if r != "" && strings.EqualFold(r[:1], rating[:1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Job describes a single tag query to download
+type Job struct {
+	Name     string `mapstructure:"name" json:"name,omitempty"`
+	Tags     string `mapstructure:"tags" json:"tags"`
+	Quantity uint16 `mapstructure:"quantity" json:"quantity,omitempty"`
+	Output   string `mapstructure:"output" json:"output,omitempty"`
+	Images   *bool  `mapstructure:"images" json:"images,omitempty"`
+	Gifs     *bool  `mapstructure:"gifs" json:"gifs,omitempty"`
+	Videos   *bool  `mapstructure:"videos" json:"videos,omitempty"`
+	Source   string `mapstructure:"source" json:"source,omitempty"`
+	Filters  Filter `mapstructure:"filters" json:"filters"`
+}
+
+// DisplayName returns the job name, falling back to its tags
+func (j Job) DisplayName() string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return j.Tags
+}
+
+// JobResult holds the outcome of a finished job
+type JobResult struct {
+	Job      Job
+	Stats    *DownloadStats
+	Err      error
+	Duration time.Duration
+}
+
+// JobReport describes a finished job for notification hooks
+type JobReport struct {
+	// Event is done or failed
+	Event    string        `json:"event"`
+	Job      string        `json:"job"`
+	Query    string        `json:"query"`
+	Output   string        `json:"output"`
+	Stats    DownloadStats `json:"stats"`
+	Files    []string      `json:"files"`
+	Error    string        `json:"error,omitempty"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+}
+
+// JobStatus is the state of a queued job
+type JobStatus string
+
+// Job states
+const (
+	JobQueued   JobStatus = "queued"
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// Finished reports whether the job will not run again
+func (s JobStatus) Finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCanceled
+}
+
+// QueuedJob is a job in the persistent job queue
+type QueuedJob struct {
+	ID         string        `json:"id"`
+	Job        Job           `json:"job"`
+	Status     JobStatus     `json:"status"`
+	Stats      DownloadStats `json:"stats"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LibraryEntry records a downloaded post and where its file was saved
+type LibraryEntry struct {
+	Post         Post      `json:"post"`
+	Path         string    `json:"path"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// Archive is the archive holding the file when it wasn't kept loose,
+	// relative to the output directory. Path is then its name inside.
+	Archive string `json:"archive,omitempty"`
+}
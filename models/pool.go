@@ -0,0 +1,16 @@
+package models
+
+// Pool represents a Rule34 pool with its posts in reading order
+type Pool struct {
+	ID      string
+	Name    string
+	PostIDs []string
+}
+
+// PoolStats holds statistics for a pool download
+type PoolStats struct {
+	DownloadStats
+	Name      string
+	Directory string
+	Archive   string
+}
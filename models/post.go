@@ -1,18 +1,28 @@
 package models
 
+import "strings"
+
 // Post represents a Rule34 post
 type Post struct {
-	ID          string `xml:"id,attr"`
-	FileURL     string `xml:"file_url,attr"`
-	SampleURL   string `xml:"sample_url,attr"`
-	PreviewURL  string `xml:"preview_url,attr"`
-	Tags        string `xml:"tags,attr"`
-	Score       int    `xml:"score,attr"`
-	Rating      string `xml:"rating,attr"`
-	Width       int    `xml:"width,attr"`
-	Height      int    `xml:"height,attr"`
-	MD5         string `xml:"md5,attr"`
-	CreatedAt   string `xml:"created_at,attr"`
+	ID          string `xml:"id,attr" json:"id"`
+	FileURL     string `xml:"file_url,attr" json:"file_url"`
+	SampleURL   string `xml:"sample_url,attr" json:"sample_url,omitempty"`
+	PreviewURL  string `xml:"preview_url,attr" json:"preview_url,omitempty"`
+	Tags        string `xml:"tags,attr" json:"tags"`
+	Score       int    `xml:"score,attr" json:"score"`
+	Rating      string `xml:"rating,attr" json:"rating"`
+	Width       int    `xml:"width,attr" json:"width"`
+	Height      int    `xml:"height,attr" json:"height"`
+	MD5         string `xml:"md5,attr" json:"md5"`
+	CreatedAt   string `xml:"created_at,attr" json:"created_at,omitempty"`
+
+	// Groups is filled in when tag categories have been resolved
+	Groups *TagGroups `xml:"-" json:"tag_groups,omitempty"`
+}
+
+// TagList returns the post tags as a slice
+func (p Post) TagList() []string {
+	return strings.Fields(p.Tags)
 }
 
 // APIResponse represents the XML response from Rule34 API
@@ -24,13 +34,22 @@ type APIResponse struct {
 // ProgressCallback is a function type for progress reporting
 type ProgressCallback func(current, total int)
 
+// StatsCallback receives a snapshot of the statistics of a running download
+type StatsCallback func(stats DownloadStats)
+
 // DownloadStats holds download statistics
 type DownloadStats struct {
-	Total       int
-	Downloaded  int
-	Skipped     int
-	Failed      int
-	Images      int
-	Gifs        int
-	Videos      int
+	Total      int `json:"total"`
+	Downloaded int `json:"downloaded"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	Images     int `json:"images"`
+	Gifs       int `json:"gifs"`
+	Videos     int `json:"videos"`
+
+	// NearDuplicates counts images that looked like an already saved image
+	NearDuplicates int `json:"near_duplicates"`
+
+	// Archives lists the archives written by the run
+	Archives []string `json:"archives,omitempty"`
 }
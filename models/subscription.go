@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Subscription is a job run repeatedly on a schedule by the watch command
+type Subscription struct {
+	Job `mapstructure:",squash"`
+
+	// Schedule is a cron expression like "0 3 * * *", a descriptor like
+	// @daily or an interval like "6h"
+	Schedule string `mapstructure:"schedule" json:"schedule"`
+	// Jitter delays each run by a random duration up to this long
+	Jitter time.Duration `mapstructure:"jitter" json:"jitter,omitempty"`
+}
+
+// SubscriptionState records the last run of a subscription
+type SubscriptionState struct {
+	LastRun  time.Time     `json:"last_run"`
+	Duration time.Duration `json:"duration"`
+	Status   JobStatus     `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Stats    DownloadStats `json:"stats"`
+	Runs     int           `json:"runs"`
+	Skipped  int           `json:"skipped"`
+}
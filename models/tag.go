@@ -0,0 +1,89 @@
+package models
+
+// TagType is the category of a tag as reported by the API
+type TagType int
+
+// Tag types used by the API
+const (
+	TagGeneral   TagType = 0
+	TagArtist    TagType = 1
+	TagCopyright TagType = 3
+	TagCharacter TagType = 4
+	TagMeta      TagType = 5
+)
+
+// String returns the name of the tag type
+func (t TagType) String() string {
+	switch t {
+	case TagArtist:
+		return "artist"
+	case TagCopyright:
+		return "copyright"
+	case TagCharacter:
+		return "character"
+	case TagMeta:
+		return "meta"
+	default:
+		return "general"
+	}
+}
+
+// Tag represents a tag entry from the API
+type Tag struct {
+	Name  string  `xml:"name,attr" json:"name"`
+	Type  TagType `xml:"type,attr" json:"type"`
+	Count int     `xml:"count,attr" json:"count"`
+}
+
+// TagResponse represents the XML response of the tag API
+type TagResponse struct {
+	Tags []Tag `xml:"tag"`
+}
+
+// TagGroups holds the tags of a post split by category
+type TagGroups struct {
+	Artist    []string `json:"artist,omitempty"`
+	Character []string `json:"character,omitempty"`
+	Copyright []string `json:"copyright,omitempty"`
+	General   []string `json:"general,omitempty"`
+	Meta      []string `json:"meta,omitempty"`
+}
+
+// Add puts a tag into the group for its type
+func (g *TagGroups) Add(name string, tagType TagType) {
+	switch tagType {
+	case TagArtist:
+		g.Artist = append(g.Artist, name)
+	case TagCharacter:
+		g.Character = append(g.Character, name)
+	case TagCopyright:
+		g.Copyright = append(g.Copyright, name)
+	case TagMeta:
+		g.Meta = append(g.Meta, name)
+	default:
+		g.General = append(g.General, name)
+	}
+}
+
+// ParseTagType converts a tag type name such as "artist" into a TagType
+func ParseTagType(name string) TagType {
+	switch name {
+	case "artist":
+		return TagArtist
+	case "copyright":
+		return TagCopyright
+	case "character":
+		return TagCharacter
+	case "meta", "metadata":
+		return TagMeta
+	default:
+		return TagGeneral
+	}
+}
+
+// RelatedTag is a tag found together with another tag
+type RelatedTag struct {
+	Tag
+	// Together is the number of sampled posts that have both tags
+	Together int
+}
@@ -0,0 +1,104 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxServerTerms is the number of terms sent to the site search. Any further
+// terms are checked locally.
+const MaxServerTerms = 20
+
+// Plan is a compiled query
+type Plan struct {
+	// Tags is the site search string for the tags= parameter
+	Tags string
+	// Filter must be checked locally against every post, or is nil when
+	// the site search alone is exact
+	Filter Node
+}
+
+// Compile parses a query and splits it into a site search and a local filter
+func Compile(input string) (*Plan, error) {
+	root, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return CompileNode(root)
+}
+
+// CompileNode splits a parsed query into a site search and a local filter.
+// Every top-level term the site understands is sent to it; the whole query
+// is kept as the local filter when any term couldn't be sent.
+func CompileNode(root Node) (*Plan, error) {
+	conjuncts := []Node{root}
+	if and, ok := root.(*And); ok {
+		conjuncts = and.Children
+	}
+
+	var positive, negative []string
+	exact := true
+	for _, node := range conjuncts {
+		term, ok := serverTerm(node)
+		if !ok {
+			exact = false
+			continue
+		}
+		if strings.HasPrefix(term, "-") {
+			negative = append(negative, term)
+		} else {
+			positive = append(positive, term)
+		}
+	}
+
+	// Positive terms narrow the search the most, so they go first
+	terms := append(positive, negative...)
+	if len(terms) > MaxServerTerms {
+		terms = terms[:MaxServerTerms]
+		exact = false
+	}
+
+	if len(positive) == 0 {
+		return nil, fmt.Errorf("query needs at least one tag the site can search for, outside of | and ! groups")
+	}
+
+	plan := &Plan{Tags: strings.Join(terms, " ")}
+	if !exact {
+		plan.Filter = root
+	}
+	return plan, nil
+}
+
+// serverTerm converts a node into site search syntax if the site supports it
+func serverTerm(node Node) (string, bool) {
+	switch n := node.(type) {
+	case *Tag:
+		return n.Name, true
+	case *Compare:
+		if n.Op == "=" {
+			return fmt.Sprintf("%s:%d", n.Field, n.Value), true
+		}
+		return fmt.Sprintf("%s:%s%d", n.Field, n.Op, n.Value), true
+	case *Rating:
+		return n.String(), true
+	case *Not:
+		switch child := n.Child.(type) {
+		case *Tag:
+			return "-" + child.Name, true
+		case *Rating:
+			return "-" + child.String(), true
+		}
+	case *Or:
+		// The site supports OR between plain tags as ( a ~ b )
+		names := make([]string, 0, len(n.Children))
+		for _, child := range n.Children {
+			tag, ok := child.(*Tag)
+			if !ok || tag.Meta {
+				return "", false
+			}
+			names = append(names, tag.Name)
+		}
+		return "( " + strings.Join(names, " ~ ") + " )", true
+	}
+	return "", false
+}
@@ -0,0 +1,333 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenAnd
+	tokenOr
+	// tokenSiteOr is the site's ~, which binds tighter than and
+	tokenSiteOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenEOF
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+var (
+	comparePattern = regexp.MustCompile(`^(score|width|height|id):?(>=|<=|>|<|=)?(-?\d+)$`)
+	ratingPattern  = regexp.MustCompile(`^rating:(\w+)$`)
+)
+
+// metaPrefixes are the site meta tags. Other tags with a colon, such as
+// re:zero_kara_hajimeru_isekai_seikatsu, are plain tags.
+var metaPrefixes = map[string]bool{
+	"sort": true, "score": true, "id": true, "md5": true, "width": true, "height": true,
+	"user": true, "parent": true, "source": true, "rating": true, "fav": true, "pool": true,
+}
+
+// Parse parses a query into an expression tree
+func Parse(input string) (Node, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, fmt.Errorf("query is empty")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos+1)
+	}
+
+	return node, nil
+}
+
+// tokenize splits a query into tokens. &, &&, |, || and ~ are only
+// operators when standing apart, so black_&_white and a|b are tags.
+// Parentheses that are part of a tag, as in hu_tao_(genshin_impact), stay
+// inside the term, and so does a ) closing no group, as in :). Other tags
+// can be quoted.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	groups := 0
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			groups++
+			i++
+		case c == ')' && groups > 0:
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			groups--
+			i++
+		case (c == '&' || c == '|') && isOperator(runes, i, 2):
+			kind := tokenAnd
+			if c == '|' {
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind, string(c), i})
+			i++
+			if i < len(runes) && runes[i] == c {
+				i++
+			}
+		case c == '~' && isOperator(runes, i, 1):
+			// The site's own OR operator
+			tokens = append(tokens, token{tokenSiteOr, "~", i})
+			i++
+		case c == '!' || (c == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1])):
+			tokens = append(tokens, token{tokenNot, string(c), i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated quote at position %d", i+1)
+			}
+			tokens = append(tokens, token{tokenTerm, string(runes[i+1 : end]), i})
+			i = end + 1
+		default:
+			start, depth := i, 0
+			for i < len(runes) {
+				c = runes[i]
+				if unicode.IsSpace(c) {
+					break
+				}
+				if c == '(' {
+					depth++
+				} else if c == ')' {
+					if depth == 0 && groups > 0 {
+						break
+					}
+					if depth > 0 {
+						depth--
+					}
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokenTerm, string(runes[start:i]), start})
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "", len(runes)}), nil
+}
+
+// isOperator checks if the operator at i, repeated up to max times, stands
+// apart from its neighbours with whitespace, a parenthesis or the ends of
+// the query
+func isOperator(runes []rune, i, max int) bool {
+	end := i + 1
+	for end < len(runes) && end-i < max && runes[end] == runes[i] {
+		end++
+	}
+	return separates(runes, i-1) && separates(runes, end)
+}
+
+func separates(runes []rune, i int) bool {
+	if i < 0 || i >= len(runes) {
+		return true
+	}
+	c := runes[i]
+	return unicode.IsSpace(c) || c == '(' || c == ')'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Node{left}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &Or{Children: flatten(children, true)}, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	first, err := p.parseSiteOr()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Node{first}
+	for {
+		switch p.peek().kind {
+		case tokenAnd:
+			p.next()
+		case tokenTerm, tokenNot, tokenLParen:
+			// Terms next to each other mean "and"
+		default:
+			if len(children) == 1 {
+				return first, nil
+			}
+			return &And{Children: flatten(children, false)}, nil
+		}
+
+		next, err := p.parseSiteOr()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+}
+
+// parseSiteOr parses the site's a ~ b, which joins neighbouring terms only,
+// so a ~ b c means (a | b) & c
+func (p *parser) parseSiteOr() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []Node{left}
+	for p.peek().kind == tokenSiteOr {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &Or{Children: flatten(children, true)}, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNot:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Child: child}, nil
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis for position %d", tok.pos+1)
+		}
+		return node, nil
+	case tokenTerm:
+		return parseTerm(tok.value)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos+1)
+	}
+}
+
+// parseTerm turns a single term into a tag, comparison or rating
+func parseTerm(term string) (Node, error) {
+	lower := strings.ToLower(term)
+
+	if match := comparePattern.FindStringSubmatch(lower); match != nil {
+		value, err := strconv.Atoi(match[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in %q", term)
+		}
+		op := match[2]
+		if op == "" {
+			op = "="
+		}
+		return &Compare{Field: match[1], Op: op, Value: value}, nil
+	}
+
+	if match := ratingPattern.FindStringSubmatch(lower); match != nil {
+		switch match[1] {
+		case "s", "safe", "g", "general":
+			return &Rating{Value: "safe"}, nil
+		case "q", "questionable":
+			return &Rating{Value: "questionable"}, nil
+		case "e", "explicit":
+			return &Rating{Value: "explicit"}, nil
+		default:
+			return nil, fmt.Errorf("unknown rating %q (expected s, q or e)", match[1])
+		}
+	}
+
+	return &Tag{Name: term, Meta: isMeta(lower)}, nil
+}
+
+// isMeta checks if a term is a site meta tag such as sort:score
+func isMeta(term string) bool {
+	prefix, _, found := strings.Cut(term, ":")
+	return found && metaPrefixes[prefix]
+}
+
+// flatten merges nested nodes of the same kind, so a & (b & c) becomes a & b & c
+func flatten(children []Node, or bool) []Node {
+	var flat []Node
+	for _, child := range children {
+		if or {
+			if nested, ok := child.(*Or); ok {
+				flat = append(flat, nested.Children...)
+				continue
+			}
+		} else if nested, ok := child.(*And); ok {
+			flat = append(flat, nested.Children...)
+			continue
+		}
+		flat = append(flat, child)
+	}
+	return flat
+}
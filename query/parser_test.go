@@ -0,0 +1,103 @@
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"black_&_white", "black_&_white"},
+		{"black_&_white solo", "black_&_white & solo"},
+		{"a|b", "a|b"},
+		{"a | b", "a | b"},
+		{"a || b", "a | b"},
+		{"a&b", "a&b"},
+		{"a & b", "a & b"},
+		{"a && b", "a & b"},
+		{"(a|b)", "a|b"},
+		{"(a | b) & c", "(a | b) & c"},
+		{":)", ":)"},
+		{":) smile", ":) & smile"},
+		{"c++ c#", "c++ & c#"},
+		{"hu_tao_(genshin_impact)", "hu_tao_(genshin_impact)"},
+		{"(hu_tao_(genshin_impact) | keqing_(genshin_impact))", "hu_tao_(genshin_impact) | keqing_(genshin_impact)"},
+		{`(a | ":)")`, "a | :)"},
+		{"a ~ b", "a | b"},
+		{"a ~ b c", "(a | b) & c"},
+		{"c a ~ b ~ d", "c & (a | b | d)"},
+		{"a ~ b | c", "a | b | c"},
+		{"-a !b", "!a & !b"},
+		{"re:zero score:>=10", "re:zero & score>=10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			node, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.input, err)
+			}
+			if got := node.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "query is empty"},
+		{"(a | b", "missing closing parenthesis"},
+		{"a &", "unexpected end of query"},
+		{"a ~", "unexpected end of query"},
+		{`"a`, "unterminated quote"},
+		{"rating:x", "unknown rating"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) error = %v, want %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input  string
+		tags   string
+		filter bool
+	}{
+		{"black_&_white", "black_&_white", false},
+		{"a|b", "a|b", false},
+		{"a | b", "( a ~ b )", false},
+		{":)", ":)", false},
+		{"a ~ b c", "( a ~ b ) c", false},
+		{"a & !re:zero_kara", "a -re:zero_kara", false},
+		{"a & !sort:score", "a -sort:score", false},
+		{"a & (b | score>10)", "a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			plan, err := Compile(tt.input)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.input, err)
+			}
+			if plan.Tags != tt.tags {
+				t.Errorf("Compile(%q).Tags = %q, want %q", tt.input, plan.Tags, tt.tags)
+			}
+			if (plan.Filter != nil) != tt.filter {
+				t.Errorf("Compile(%q).Filter = %v, want a filter: %v", tt.input, plan.Filter, tt.filter)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package query implements the tag query language used to search posts.
+//
+// A query combines tags with & (and), | (or), ! (not) and parentheses, plus
+// comparisons on post fields:
+//
+//	(a | b) & !c & score>50 & rating:s
+//
+// Whitespace between terms means "and", and the site syntax (-tag, ~ and
+// score:>50) is accepted too, so plain tag lists keep working. As on the
+// site, ~ only joins its neighbours: a ~ b c means (a | b) & c. Operators
+// must stand apart, so tags such as black_&_white or :) need no quoting.
+// Compile
+// splits a query into the part the site can search for and the part that
+// has to be checked locally against each post.
+package query
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"r34-go/models"
+)
+
+// Node is an expression that can be checked against a post
+type Node interface {
+	// Eval reports whether the post matches the expression
+	Eval(post models.Post) bool
+	// String returns the expression in query syntax
+	String() string
+}
+
+// And matches posts matching every child
+type And struct {
+	Children []Node
+}
+
+// Eval reports whether the post matches every child
+func (n *And) Eval(post models.Post) bool {
+	for _, child := range n.Children {
+		if !child.Eval(post) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *And) String() string {
+	return joinNodes(n.Children, " & ")
+}
+
+// Or matches posts matching any child
+type Or struct {
+	Children []Node
+}
+
+// Eval reports whether the post matches any child
+func (n *Or) Eval(post models.Post) bool {
+	for _, child := range n.Children {
+		if child.Eval(post) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Or) String() string {
+	return joinNodes(n.Children, " | ")
+}
+
+// Not matches posts that don't match its child
+type Not struct {
+	Child Node
+}
+
+// Eval reports whether the post doesn't match the child. Negated meta tags
+// are left to the site like the others and always match.
+func (n *Not) Eval(post models.Post) bool {
+	if tag, ok := n.Child.(*Tag); ok && tag.Meta {
+		return true
+	}
+	return !n.Child.Eval(post)
+}
+
+func (n *Not) String() string {
+	return "!" + joinNodes([]Node{n.Child}, "")
+}
+
+// Tag matches posts having a tag. Names may contain * wildcards.
+type Tag struct {
+	Name string
+	// Meta marks site meta tags such as sort:score that can only be
+	// handled by the site and always match locally
+	Meta bool
+}
+
+// Eval reports whether the post has the tag
+func (n *Tag) Eval(post models.Post) bool {
+	if n.Meta {
+		return true
+	}
+
+	name := strings.ToLower(n.Name)
+	wildcard := strings.Contains(name, "*")
+	for _, tag := range post.TagList() {
+		tag = strings.ToLower(tag)
+		if wildcard {
+			if matched, _ := path.Match(name, tag); matched {
+				return true
+			}
+		} else if tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Tag) String() string {
+	return n.Name
+}
+
+// Compare matches posts whose numeric field compares to a value,
+// e.g. score>50 or width>=1920
+type Compare struct {
+	Field string
+	Op    string
+	Value int
+}
+
+// Eval reports whether the post field satisfies the comparison
+func (n *Compare) Eval(post models.Post) bool {
+	var actual int
+	switch n.Field {
+	case "score":
+		actual = post.Score
+	case "width":
+		actual = post.Width
+	case "height":
+		actual = post.Height
+	case "id":
+		actual, _ = strconv.Atoi(post.ID)
+	}
+
+	switch n.Op {
+	case ">":
+		return actual > n.Value
+	case ">=":
+		return actual >= n.Value
+	case "<":
+		return actual < n.Value
+	case "<=":
+		return actual <= n.Value
+	default:
+		return actual == n.Value
+	}
+}
+
+func (n *Compare) String() string {
+	return fmt.Sprintf("%s%s%d", n.Field, n.Op, n.Value)
+}
+
+// Rating matches posts with a rating
+type Rating struct {
+	// Value is the full rating name: safe, questionable or explicit
+	Value string
+}
+
+// Eval reports whether the post has the rating
+func (n *Rating) Eval(post models.Post) bool {
+	return post.Rating != "" && strings.EqualFold(post.Rating[:1], n.Value[:1])
+}
+
+func (n *Rating) String() string {
+	return "rating:" + n.Value
+}
+
+func joinNodes(nodes []Node, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		switch node.(type) {
+		case *And, *Or:
+			parts[i] = "(" + node.String() + ")"
+		default:
+			parts[i] = node.String()
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,245 @@
+// Package schedule parses the run schedules of subscriptions: standard
+// five-field cron expressions, descriptors such as @daily and intervals.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchYears bounds the search for the next matching time, so that
+// expressions like "0 0 30 2 *" fail instead of looping forever
+const searchYears = 5
+
+// Schedule tells when a job runs next
+type Schedule interface {
+	// Next returns the first run time after t
+	Next(t time.Time) time.Time
+	String() string
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a schedule: a cron expression like "30 3 * * 1-5", a
+// descriptor like @daily, or an interval like "6h" or "@every 6h"
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		return parseInterval(strings.TrimSpace(rest))
+	}
+	if expr, ok := descriptors[strings.ToLower(spec)]; ok {
+		return parseCron(expr, spec)
+	}
+	if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("unknown schedule descriptor %q", spec)
+	}
+	if len(strings.Fields(spec)) == 1 {
+		return parseInterval(spec)
+	}
+	return parseCron(spec, spec)
+}
+
+// Interval runs a job a fixed duration after the previous run
+type Interval time.Duration
+
+// Next returns t plus the interval
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i Interval) String() string {
+	return "@every " + time.Duration(i).String()
+}
+
+func parseInterval(spec string) (Schedule, error) {
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval %q: %w", spec, err)
+	}
+	if d < time.Minute {
+		return nil, fmt.Errorf("interval %s is shorter than a minute", d)
+	}
+	return Interval(d), nil
+}
+
+// Cron is a five-field cron schedule: minute, hour, day of month, month and
+// day of week, evaluated in local time
+type Cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	// When both day fields are restricted a day matching either runs,
+	// as in classic cron
+	domAny, dowAny bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+func parseCron(expr, spec string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields (minute hour day month weekday), got %d", spec, len(fields))
+	}
+
+	c := &Cron{spec: spec}
+	var err error
+	if c.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if c.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if c.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if c.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if c.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", spec)
+	}
+	return c, nil
+}
+
+// parse reads a comma-separated list of values, ranges and steps into a
+// bit set
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepSpec, f.name)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangeSpec == "*":
+		case strings.Contains(rangeSpec, "-"):
+			from, to, _ := strings.Cut(rangeSpec, "-")
+			var err error
+			if lo, err = f.value(from); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(to); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeSpec, f.name)
+			}
+		default:
+			n, err := f.value(rangeSpec)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid %s %q (expected %d-%d)", f.name, s, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the first matching minute after t, or the zero time when
+// nothing matches within the next years
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(searchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"r34-go/metrics"
+	"r34-go/models"
+	"r34-go/services"
+)
+
+// maxRequestBody limits the size of submitted jobs
+const maxRequestBody = 1 << 20
+
+// API serves the REST API of the download daemon:
+//
+//	POST   /jobs       submit a job
+//	GET    /jobs       list jobs
+//	GET    /jobs/{id}  job status and statistics
+//	DELETE /jobs/{id}  cancel a job
+//	GET    /events     stream progress events as Server-Sent Events
+//	GET    /metrics    Prometheus metrics, when enabled
+type API struct {
+	queue   *services.JobQueue
+	token   string
+	events  *EventBroker
+	metrics *metrics.Registry
+}
+
+// NewAPI creates the REST API for a job queue and publishes the progress
+// events of its jobs. When token is set, requests must send it as a bearer
+// token.
+func NewAPI(queue *services.JobQueue, token string) *API {
+	events := NewEventBroker()
+	queue.SetEvents(events)
+	return &API{queue: queue, token: token, events: events}
+}
+
+// SetMetrics serves the metrics of reg at /metrics
+func (a *API) SetMetrics(reg *metrics.Registry) {
+	a.metrics = reg
+}
+
+// Handler returns the HTTP handler of the API
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", a.handleHealth)
+	mux.HandleFunc("POST /jobs", a.handleSubmit)
+	mux.HandleFunc("GET /jobs", a.handleList)
+	mux.HandleFunc("GET /jobs/{id}", a.handleGet)
+	mux.HandleFunc("DELETE /jobs/{id}", a.handleCancel)
+	mux.Handle("GET /events", a.events)
+	if a.metrics != nil {
+		mux.Handle("GET /metrics", a.metrics.Handler())
+	}
+	return a.authenticate(mux)
+}
+
+func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *API) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var job models.Job
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&job); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job: "+err.Error())
+		return
+	}
+
+	queued, err := a.queue.Submit(job)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+queued.ID)
+	writeJSON(w, http.StatusCreated, queued)
+}
+
+func (a *API) handleList(w http.ResponseWriter, r *http.Request) {
+	status := models.JobStatus(r.URL.Query().Get("status"))
+
+	jobs := []models.QueuedJob{}
+	for _, job := range a.queue.List() {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (a *API) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.queue.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, services.ErrJobNotFound.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (a *API) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, err := a.queue.Cancel(r.PathValue("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrJobFinished):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// authenticate rejects requests without the bearer token when one is set
+func (a *API) authenticate(next http.Handler) http.Handler {
+	if a.token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + a.token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"r34-go/models"
+)
+
+const (
+	// subscriberBuffer is the number of events queued for a slow client
+	// before further events are dropped
+	subscriberBuffer = 256
+	// keepAliveInterval is how often an idle stream sends a comment
+	keepAliveInterval = 30 * time.Second
+)
+
+// EventBroker is an event sink streaming events to HTTP clients as
+// Server-Sent Events. Clients that fall behind miss events rather than
+// slowing down downloads.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan models.Event]string
+}
+
+// NewEventBroker creates an event broker without subscribers
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan models.Event]string)}
+}
+
+// Publish sends the event to every subscriber watching its job
+func (b *EventBroker) Publish(event models.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch, job := range b.subscribers {
+		if job != "" && job != event.Job {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams events until the client disconnects. The job query
+// parameter limits the stream to a single job.
+func (b *EventBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	ch := b.subscribe(r.URL.Query().Get("job"))
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (b *EventBroker) subscribe(job string) chan models.Event {
+	ch := make(chan models.Event, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = job
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *EventBroker) unsubscribe(ch chan models.Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
@@ -0,0 +1,366 @@
+// Package server implements the HTTP interfaces of r34-go: the web gallery
+// for a downloaded library and the REST API of the download daemon.
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"r34-go/models"
+	"r34-go/query"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+const (
+	galleryPageSize = 60
+	reloadInterval  = 10 * time.Second
+	viewsDir        = "views"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// item is a file of the library shown in the gallery
+type item struct {
+	models.LibraryEntry
+	// Kind is image, gif or video
+	Kind string
+}
+
+// Gallery serves a web gallery of the files in an output directory
+type Gallery struct {
+	dir       string
+	templates *template.Template
+	thumbs    *thumbnailer
+
+	mu       sync.Mutex
+	items    []item
+	loadedAt time.Time
+}
+
+// NewGallery creates a gallery for an output directory
+func NewGallery(dir string) (*Gallery, error) {
+	templates, err := template.New("").Funcs(template.FuncMap{
+		"fileURL":    func(path string) string { return "/files/" + escapePath(path) },
+		"thumbURL":   func(path string) string { return "/thumbs/" + escapePath(path) },
+		"postURL":    func(path string) string { return "/post/" + escapePath(path) },
+		"searchURL":  func(q string) string { return "/?q=" + url.QueryEscape(q) },
+		"siteURL":    services.PostURL,
+		"ratingName": services.RatingName,
+		"base":       filepath.Base,
+	}).ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gallery{
+		dir:       dir,
+		templates: templates,
+		thumbs:    newThumbnailer(dir),
+	}, nil
+}
+
+// Handler returns the HTTP handler of the gallery
+func (g *Gallery) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", g.handleIndex)
+	mux.HandleFunc("GET /post/{path...}", g.handlePost)
+	mux.HandleFunc("GET /thumbs/{path...}", g.handleThumb)
+	mux.Handle("GET /files/", http.StripPrefix("/files/", hideDotfiles(http.FileServer(http.Dir(g.dir)))))
+	return mux
+}
+
+// indexPage is the data of the gallery page
+type indexPage struct {
+	Query   string
+	Error   string
+	Items   []item
+	Total   int
+	Page    int
+	Pages   int
+	PrevURL string
+	NextURL string
+}
+
+// Title returns the page title
+func (p indexPage) Title() string {
+	if p.Query != "" {
+		return p.Query
+	}
+	return "Gallery"
+}
+
+func (g *Gallery) handleIndex(w http.ResponseWriter, r *http.Request) {
+	items, err := g.load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := indexPage{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if data.Query != "" {
+		node, err := query.Parse(data.Query)
+		if err != nil {
+			data.Error = err.Error()
+			items = nil
+		} else {
+			var matching []item
+			for _, it := range items {
+				if node.Eval(it.Post) {
+					matching = append(matching, it)
+				}
+			}
+			items = matching
+		}
+	}
+
+	data.Total = len(items)
+	data.Pages = (len(items) + galleryPageSize - 1) / galleryPageSize
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
+	}
+	if data.Pages > 0 && data.Page > data.Pages {
+		data.Page = data.Pages
+	}
+
+	start := (data.Page - 1) * galleryPageSize
+	end := min(start+galleryPageSize, len(items))
+	if start < end {
+		data.Items = items[start:end]
+	}
+	if data.Page > 1 {
+		data.PrevURL = pageURL(data.Query, data.Page-1)
+	}
+	if data.Page < data.Pages {
+		data.NextURL = pageURL(data.Query, data.Page+1)
+	}
+
+	g.render(w, "index.html", data)
+}
+
+// postPage is the data of a post detail page
+type postPage struct {
+	Query string
+	Item  item
+	Tags  []tagGroup
+	JSON  string
+}
+
+// Title returns the page title
+func (p postPage) Title() string {
+	if p.Item.Post.ID != "" {
+		return "#" + p.Item.Post.ID
+	}
+	return filepath.Base(p.Item.Path)
+}
+
+// tagGroup is a titled list of tags on the post page
+type tagGroup struct {
+	Title string
+	Tags  []string
+}
+
+func (g *Gallery) handlePost(w http.ResponseWriter, r *http.Request) {
+	items, err := g.load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	path := r.PathValue("path")
+	for _, it := range items {
+		if it.Path != path {
+			continue
+		}
+
+		raw, _ := json.MarshalIndent(it.Post, "", "  ")
+		g.render(w, "post.html", postPage{Item: it, Tags: tagGroups(it.Post), JSON: string(raw)})
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (g *Gallery) handleThumb(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	if hasDotSegment(path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	thumbPath, err := g.thumbs.thumbnail(path)
+	if err != nil {
+		// Formats that can't be decoded here are shown as they are
+		http.Redirect(w, r, "/files/"+escapePath(path), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeFile(w, r, thumbPath)
+}
+
+func (g *Gallery) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := g.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Failed to render %s: %v", name, err)
+	}
+}
+
+// load returns the files of the library, newest first. The result is cached
+// for a few seconds so that new downloads show up without a restart.
+func (g *Gallery) load() ([]item, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.items != nil && time.Since(g.loadedAt) < reloadInterval {
+		return g.items, nil
+	}
+
+	library, err := services.OpenLibrary(g.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []item{}
+	indexed := make(map[string]bool)
+	for _, entry := range library.Entries() {
+		indexed[entry.Path] = true
+		items = append(items, item{
+			LibraryEntry: entry,
+			Kind:         utils.ClassifyFileType(filepath.Ext(entry.Path)),
+		})
+	}
+
+	// Files downloaded before the library index existed are still shown,
+	// with metadata from their sidecar when there is one
+	err = filepath.WalkDir(g.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		relPath, _ := filepath.Rel(g.dir, path)
+		relPath = filepath.ToSlash(relPath)
+
+		if d.IsDir() {
+			if path != g.dir && (strings.HasPrefix(d.Name(), ".") || relPath == viewsDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if indexed[relPath] || strings.HasPrefix(d.Name(), ".") || !utils.IsValidFileExtension(filepath.Ext(path)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		items = append(items, item{
+			LibraryEntry: models.LibraryEntry{
+				Post:         readSidecar(path),
+				Path:         relPath,
+				DownloadedAt: info.ModTime(),
+			},
+			Kind: utils.ClassifyFileType(filepath.Ext(path)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].DownloadedAt.Equal(items[j].DownloadedAt) {
+			return items[i].DownloadedAt.After(items[j].DownloadedAt)
+		}
+		return items[i].Path < items[j].Path
+	})
+
+	g.items = items
+	g.loadedAt = time.Now()
+	return items, nil
+}
+
+// readSidecar reads the metadata sidecar of a file, returning an empty post
+// when there is none
+func readSidecar(filePath string) models.Post {
+	var post models.Post
+	if data, err := os.ReadFile(filePath + ".json"); err == nil {
+		json.Unmarshal(data, &post)
+	}
+	return post
+}
+
+// tagGroups splits the tags of a post by category when they are known
+func tagGroups(post models.Post) []tagGroup {
+	if post.Groups == nil {
+		return []tagGroup{{Title: "Tags", Tags: post.TagList()}}
+	}
+
+	var groups []tagGroup
+	for _, group := range []tagGroup{
+		{"Artists", post.Groups.Artist},
+		{"Characters", post.Groups.Character},
+		{"Copyrights", post.Groups.Copyright},
+		{"General", post.Groups.General},
+		{"Meta", post.Groups.Meta},
+	} {
+		if len(group.Tags) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func pageURL(q string, page int) string {
+	values := url.Values{}
+	if q != "" {
+		values.Set("q", q)
+	}
+	values.Set("page", strconv.Itoa(page))
+	return "/?" + values.Encode()
+}
+
+// escapePath escapes each segment of a slash separated path for use in URLs
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// hasDotSegment checks if a path goes through a hidden file or directory,
+// which includes the indexes of the output directory
+func hasDotSegment(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// hideDotfiles refuses requests for hidden files
+func hideDotfiles(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasDotSegment(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
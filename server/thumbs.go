@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+
+	// Thumbnails are made from JPEG, PNG and GIF files
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	thumbDir     = ".thumbs"
+	thumbMaxSize = 320
+)
+
+// thumbnailer creates gallery thumbnails on first request and keeps them in
+// a hidden directory of the output directory
+type thumbnailer struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newThumbnailer(dir string) *thumbnailer {
+	return &thumbnailer{dir: dir}
+}
+
+// thumbnail returns the path of the thumbnail of a file relative to the
+// output directory, creating it if it is missing or outdated
+func (t *thumbnailer) thumbnail(relPath string) (string, error) {
+	source := filepath.Join(t.dir, filepath.FromSlash(relPath))
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum([]byte(relPath))
+	thumbPath := filepath.Join(t.dir, thumbDir, hex.EncodeToString(sum[:])+".jpg")
+
+	// Thumbnails are only created one at a time to bound memory use
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if info, err := os.Stat(thumbPath); err == nil && !info.ModTime().Before(sourceInfo.ModTime()) {
+		return thumbPath, nil
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s: %w", relPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return "", err
+	}
+	out, err := os.Create(thumbPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if err := jpeg.Encode(out, shrink(img, thumbMaxSize), &jpeg.Options{Quality: 80}); err != nil {
+		os.Remove(thumbPath)
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// shrink scales img down so that neither side exceeds maxSize, averaging
+// the source pixels covered by each thumbnail pixel
+func shrink(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+
+	newWidth, newHeight := maxSize, height*maxSize/width
+	if height > width {
+		newWidth, newHeight = width*maxSize/height, maxSize
+	}
+	newWidth, newHeight = max(newWidth, 1), max(newHeight, 1)
+
+	thumb := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := max(bounds.Min.Y+(y+1)*height/newHeight, y0+1)
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := max(bounds.Min.X+(x+1)*width/newWidth, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			i := thumb.PixOffset(x, y)
+			thumb.Pix[i+0] = uint8(r / n >> 8)
+			thumb.Pix[i+1] = uint8(g / n >> 8)
+			thumb.Pix[i+2] = uint8(b / n >> 8)
+			thumb.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return thumb
+}
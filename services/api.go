@@ -3,41 +3,46 @@ package services
 import (
 	"encoding/xml"
 	"fmt"
-	"net/http"
 	"path/filepath"
-	"strings"
-	"time"
 
-	"r34-go/config"
 	"r34-go/models"
 )
 
-const (
-	apiURL   = "https://rule34.xxx/index.php?page=dapi&s=post&q=index"
-	pageSize = 100
-)
+const pageSize = 100
 
 // APIService handles Rule34 API interactions
 type APIService struct {
-	client          *http.Client
+	client          *Client
 	downloadService *DownloadService
+	tagService      *TagService
+	options         DownloadOptions
+	output          *outputState
 }
 
 // NewAPIService creates a new API service instance
 func NewAPIService() *APIService {
+	return NewAPIServiceWithClient(NewClient())
+}
+
+// NewAPIServiceWithClient creates a new API service instance sharing the given client
+func NewAPIServiceWithClient(client *Client) *APIService {
 	return &APIService{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		downloadService: NewDownloadService(),
+		client:          client,
+		downloadService: NewDownloadServiceWithClient(client),
+		options:         OptionsFromSettings(),
 	}
 }
 
+// SetOptions overrides the file type switches and filter used for downloads
+func (as *APIService) SetOptions(options DownloadOptions) {
+	as.options = options
+}
+
 // GetContentCount returns the total number of posts for given tags
 func (as *APIService) GetContentCount(tags string) (int, error) {
-	url := fmt.Sprintf("%s&tags=%s", apiURL, tags)
+	url := apiRequest("post").tags(tags).String()
 	
-	resp, err := as.client.Get(url)
+	resp, err := as.client.getPage(url)
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch content count: %w", err)
 	}
@@ -51,18 +56,115 @@ func (as *APIService) GetContentCount(tags string) (int, error) {
 	return apiResp.Count, nil
 }
 
+// GetPost fetches a single post by its ID
+func (as *APIService) GetPost(id string) (*models.Post, error) {
+	url := apiRequest("post").set("id", id).String()
+
+	apiResp, err := as.fetchPosts(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch post %s: %w", id, err)
+	}
+
+	if len(apiResp.Posts) == 0 {
+		return nil, fmt.Errorf("post %s not found", id)
+	}
+
+	return &apiResp.Posts[0], nil
+}
+
+// GetPosts fetches a single page of posts for given tags
+func (as *APIService) GetPosts(tags string, pid, limit int) ([]models.Post, error) {
+	url := apiRequest("post").tags(tags).setInt("pid", pid).setInt("limit", limit).String()
+
+	apiResp, err := as.fetchPosts(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page %d: %w", pid, err)
+	}
+
+	return apiResp.Posts, nil
+}
+
+// Matching returns the posts passing the filters and the query of the
+// options, resolving tag categories first when they are needed
+func (as *APIService) Matching(posts []models.Post) []models.Post {
+	as.categorize(posts)
+
+	matching := posts[:0]
+	for _, post := range posts {
+		if as.options.matches(post) {
+			matching = append(matching, post)
+		}
+	}
+	return matching
+}
+
+// DownloadPosts downloads already resolved posts using the standard folder
+// layout. The error is that of the archive, download failures are counted
+// in the stats.
+func (as *APIService) DownloadPosts(posts []models.Post, path string, progressCallback models.ProgressCallback) (*models.DownloadStats, error) {
+	stats := &models.DownloadStats{Total: len(posts)}
+
+	as.output = openOutput(as.options, path)
+
+	as.categorize(posts)
+
+	for i, post := range posts {
+		result := as.downloadPost(post, path, stats)
+		switch result.status {
+		case "downloaded":
+			stats.Downloaded++
+		case "skipped":
+			stats.Skipped++
+		case "failed":
+			stats.Failed++
+		}
+
+		as.options.emitResult(post, result, i+1, len(posts))
+		as.client.recordResult(result)
+		if progressCallback != nil {
+			progressCallback(i+1, len(posts))
+		}
+	}
+
+	err := as.closeOutput(stats)
+	as.options.emit(models.Event{Type: models.EventRunFinished, Stats: stats})
+	return stats, err
+}
+
+func (as *APIService) fetchPosts(url string) (*models.APIResponse, error) {
+	resp, err := as.client.getPage(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp models.APIResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode XML response: %w", err)
+	}
+
+	return &apiResp, nil
+}
+
 // DownloadContent downloads posts using the API method
-func (as *APIService) DownloadContent(path, tags string, quantity uint16, progressCallback models.ProgressCallback) (*models.DownloadStats, error) {
-	stats := &models.DownloadStats{Total: int(quantity)}
+func (as *APIService) DownloadContent(path, tags string, quantity uint16, progressCallback models.ProgressCallback) (stats *models.DownloadStats, err error) {
+	stats = &models.DownloadStats{Total: int(quantity)}
+
+	as.output = openOutput(as.options, path)
+	defer func() {
+		if closeErr := as.closeOutput(stats); err == nil {
+			err = closeErr
+		}
+	}()
 	
 	downloaded := 0
 	pid := 0
 	
 	// Keep fetching pages until we have enough content or run out of pages
 	for downloaded < int(quantity) {
-		url := fmt.Sprintf("%s&tags=%s&pid=%d", apiURL, tags, pid)
+		url := apiRequest("post").tags(tags).setInt("pid", pid).String()
 		
-		resp, err := as.client.Get(url)
+		resp, err := as.client.getPage(url)
 		if err != nil {
 			return stats, fmt.Errorf("failed to fetch page %d: %w", pid, err)
 		}
@@ -74,33 +176,42 @@ func (as *APIService) DownloadContent(path, tags string, quantity uint16, progre
 		}
 		resp.Body.Close()
 
+		as.options.emit(models.Event{Type: models.EventPageFetched, Page: pid, Posts: len(apiResp.Posts)})
+
 		// If no posts found, we've reached the end
 		if len(apiResp.Posts) == 0 {
 			break
 		}
 
-		// Process posts on this page
-		remaining := int(quantity) - downloaded
-		postsToProcess := len(apiResp.Posts)
-		if remaining < postsToProcess {
-			postsToProcess = remaining
-		}
+		as.categorize(apiResp.Posts)
 
-		for i := 0; i < postsToProcess; i++ {
-			post := apiResp.Posts[i]
-			
-			downloadResult := as.downloadPost(post, path, stats)
-			if downloadResult == "downloaded" {
+		// Process posts on this page, stopping once enough have been handled
+		for _, post := range apiResp.Posts {
+			if err := as.options.canceled(); err != nil {
+				return stats, err
+			}
+
+			// Filtered posts don't count towards the requested quantity
+			if !as.options.matches(post) {
+				as.options.emit(models.Event{Type: models.EventPostSkipped, PostID: post.ID, Reason: "filtered"})
+				continue
+			}
+
+			result := as.downloadPost(post, path, stats)
+			if result.status == "downloaded" {
 				stats.Downloaded++
 				downloaded++
-			} else if downloadResult == "skipped" {
+			} else if result.status == "skipped" {
 				stats.Skipped++
 				downloaded++ // Count skipped as processed
-			} else if downloadResult == "failed" {
+			} else if result.status == "failed" {
 				stats.Failed++
 			}
 			// If disabled file type, don't count towards downloaded but continue
-			
+
+			as.options.emitResult(post, result, downloaded, int(quantity))
+			as.client.recordResult(result)
+			as.options.reportStats(stats)
 			if progressCallback != nil {
 				progressCallback(downloaded, int(quantity))
 			}
@@ -109,9 +220,6 @@ func (as *APIService) DownloadContent(path, tags string, quantity uint16, progre
 			if downloaded >= int(quantity) {
 				break
 			}
-			
-			// Small delay to avoid overwhelming the server
-			time.Sleep(100 * time.Millisecond)
 		}
 		
 		// Move to next page
@@ -123,97 +231,79 @@ func (as *APIService) DownloadContent(path, tags string, quantity uint16, progre
 		}
 	}
 
+	as.options.emit(models.Event{Type: models.EventRunFinished, Stats: stats})
 	return stats, nil
 }
 
-func (as *APIService) downloadPost(post models.Post, basePath string, stats *models.DownloadStats) string {
+// postResult is the outcome of downloading a single post
+type postResult struct {
+	// status is downloaded, skipped, failed or disabled
+	status string
+	file   string
+	reason string
+}
+
+func (as *APIService) downloadPost(post models.Post, basePath string, stats *models.DownloadStats) postResult {
 	if post.FileURL == "" {
-		return "failed"
+		return postResult{status: "failed", reason: "post has no file URL"}
 	}
 
-	fileExt := strings.ToLower(filepath.Ext(post.FileURL))
-	filename := post.ID + fileExt
-
-	var filePath string
-	var shouldDownload bool
+	file, disabled := as.options.fileFor(post)
+	if disabled != "" {
+		return postResult{status: "disabled", reason: disabled}
+	}
+	if existing, ok := as.output.find(post); ok {
+		// Already saved, maybe imported under another name or archived
+		return postResult{status: "skipped", file: existing, reason: "already in library"}
+	}
+	if file.folder == "Images" && as.output.isSkipped(file.name) {
+		// Removed earlier as a near-duplicate
+		return postResult{status: "skipped", reason: "near duplicate"}
+	}
+	folder, downloadURL := file.folder, file.url
 
-	switch fileExt {
-	case ".mp4", ".webm":
-		if !config.AppSettings.Video {
-			return "disabled" // File type disabled
-		}
-		shouldDownload = true
-		
-		// Use sample URL if available for videos
-		downloadURL := post.SampleURL
-		if downloadURL == "" {
-			downloadURL = post.FileURL
-		}
-		
-		filePath = filepath.Join(basePath, "Video", filename)
-		err := as.downloadService.Download(downloadURL, filePath)
-		if err != nil {
-			if err.Error() == "file already exists" {
-				return "skipped"
-			}
-			return "failed"
+	filePath := filepath.Join(basePath, folder, file.name)
+	onBytes := as.options.byteProgress(post, downloadURL, filePath)
+	result, err := as.output.save(as.downloadService, post, downloadURL, filePath, onBytes)
+	if err != nil {
+		if err.Error() == "file already exists" {
+			as.output.record(post, filePath)
+			return postResult{status: "skipped", file: filePath, reason: err.Error()}
 		}
+		return postResult{status: "failed", file: filePath, reason: err.Error()}
+	}
+	if result.match != "" {
+		stats.NearDuplicates++
+	}
+	if result.skipped {
+		return postResult{status: "skipped", file: filePath, reason: "near duplicate of " + result.match}
+	}
+
+	switch folder {
+	case "Video":
 		stats.Videos++
-		
-	case ".gif":
-		if !config.AppSettings.Gif {
-			return "disabled" // File type disabled
-		}
-		shouldDownload = true
-		
-		filePath = filepath.Join(basePath, "Gif", filename)
-		err := as.downloadService.Download(post.FileURL, filePath)
-		if err != nil {
-			if err.Error() == "file already exists" {
-				return "skipped"
-			}
-			return "failed"
-		}
+	case "Gif":
 		stats.Gifs++
-		
 	default:
-		if !config.AppSettings.Images {
-			return "disabled" // File type disabled
-		}
-		shouldDownload = true
-		
-		filePath = filepath.Join(basePath, "Images", filename)
-		err := as.downloadService.Download(post.FileURL, filePath)
-		if err != nil {
-			if err.Error() == "file already exists" {
-				return "skipped"
-			}
-			return "failed"
-		}
 		stats.Images++
 	}
-
-	if shouldDownload {
-		return "downloaded"
-	}
-	
-	return "skipped"
+	return postResult{status: "downloaded", file: filePath, reason: result.warning}
 }
 
-func (as *APIService) calculateMaxPid(quantity uint16) int {
-	if quantity <= pageSize {
-		return 0
+// categorize resolves the tag groups of posts when an option needs them.
+// Lookup errors leave the tags uncategorized rather than failing the run.
+func (as *APIService) categorize(posts []models.Post) {
+	if !as.options.needsCategories() {
+		return
 	}
-	if quantity%pageSize == 0 {
-		return int(quantity/pageSize) - 1
+	if as.tagService == nil {
+		as.tagService = NewTagServiceWithClient(as.client)
 	}
-	return int(quantity / pageSize)
+	as.tagService.CategorizePosts(posts)
 }
 
-func (as *APIService) calculatePostCount(quantity uint16, pid int) int {
-	remaining := int(quantity) - pid*pageSize
-	if remaining < pageSize {
-		return remaining
-	}
-	return pageSize
+func (as *APIService) closeOutput(stats *models.DownloadStats) error {
+	err := as.output.close(stats)
+	as.output = nil
+	return err
 }
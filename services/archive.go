@@ -0,0 +1,499 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"r34-go/models"
+)
+
+// ArchiveFormats lists the formats supported by ArchiveWriter
+var ArchiveFormats = []string{"zip", "tar.gz", "cbz"}
+
+const (
+	// archiveManifest lists the posts of an archive
+	archiveManifest = "manifest.json"
+	// archiveOverhead is the room kept for the headers of each file when
+	// splitting by size
+	archiveOverhead = 1024
+)
+
+// ArchiveOptions controls the archive of a download run
+type ArchiveOptions struct {
+	// Format is zip, tar.gz or cbz; empty or none keeps loose files only
+	Format string
+	// SplitSize is the largest size of an archive part, zero writes a
+	// single archive
+	SplitSize int64
+	// KeepFiles keeps the saved files next to the archive, otherwise
+	// downloads are streamed straight into it
+	KeepFiles bool
+}
+
+func (o ArchiveOptions) enabled() bool {
+	return o.Format != "" && o.Format != "none"
+}
+
+// manifestEntry is a post in the manifest with the path of its file in the
+// archive
+type manifestEntry struct {
+	models.Post
+	Path string `json:"path"`
+}
+
+// ArchivedPost is a post whose file went into a completed archive part
+type ArchivedPost struct {
+	Post models.Post
+	// Name is the path of the file inside the archive at Archive
+	Name    string
+	Archive string
+}
+
+// ArchiveWriter streams files into zip, tar.gz or cbz archives, each ending
+// with a manifest.json of the posts in it. With a split size the files go to
+// numbered parts name-001.zip, name-002.zip and so on, none of which grows
+// past the size unless a single file does. A part is written to a temporary
+// file and renamed once complete.
+//
+// Content that isn't on disk yet, such as a download, is streamed in with
+// AddReader so it never needs room outside the archive.
+type ArchiveWriter struct {
+	base   string
+	format string
+	split  int64
+	// remove deletes the added files once their part is complete
+	remove bool
+
+	file    *os.File
+	current string
+	zip     *zip.Writer
+	gzip    *gzip.Writer
+	tar     *tar.Writer
+	// size estimates the current part, names maps its file names to their
+	// source files, empty for streamed content, manifest, posts and added
+	// are its manifest entries, posts and source files
+	size     int64
+	names    map[string]string
+	manifest [][]byte
+	posts    []manifestEntry
+	added    []string
+
+	// archived are the posts of the completed parts by part number
+	paths    []string
+	archived map[int][]manifestEntry
+	err      error
+}
+
+// NewArchiveWriter creates a writer of archives named base plus the
+// extension of format. Nothing is written until the first file is added.
+func NewArchiveWriter(base, format string, split int64) (*ArchiveWriter, error) {
+	if archiveExt(format) == "" {
+		return nil, fmt.Errorf("unknown archive format %q (expected %s)", format, strings.Join(ArchiveFormats, ", "))
+	}
+	return &ArchiveWriter{base: base, format: format, split: split}, nil
+}
+
+// ArchiveBase picks the archive path of a directory, without extension. An
+// archive of an earlier run is never replaced since it may hold the only
+// copy of its files, the new one gets a timestamp instead.
+func ArchiveBase(dir, format string) string {
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		base = filepath.Clean(dir)
+	}
+
+	ext := archiveExt(format)
+	for _, existing := range []string{base + ext, base + "-001" + ext} {
+		if _, err := os.Lstat(existing); err == nil {
+			return base + "-" + time.Now().Format("20060102-150405")
+		}
+	}
+	return base
+}
+
+func archiveExt(format string) string {
+	switch format {
+	case "zip":
+		return ".zip"
+	case "tar.gz":
+		return ".tar.gz"
+	case "cbz":
+		return ".cbz"
+	}
+	return ""
+}
+
+// SetRemoveFiles makes the writer delete each added file and its metadata
+// sidecar once the part holding it is complete
+func (aw *ArchiveWriter) SetRemoveFiles(remove bool) {
+	aw.remove = remove
+}
+
+// Add streams a file into the archive as name, a slash-separated path, and
+// lists post in the manifest when given. CBZ archives only keep base names
+// since comic readers expect a flat list of pages, a number is added to
+// those already taken by another file. The first write error stops the
+// writer and is returned by every later call.
+func (aw *ArchiveWriter) Add(post *models.Post, filePath, name string) error {
+	if aw.err != nil {
+		return aw.err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return aw.add(post, file, info.Size(), info.ModTime(), filePath, name)
+}
+
+// AddReader streams size bytes of r into the archive like Add, size being
+// -1 when unknown. tar.gz entries need their size up front, so content of
+// unknown size is first spooled to a temporary file next to the archive. A
+// read error is returned without stopping the writer, the entry it leaves
+// truncated is not listed in the manifest.
+func (aw *ArchiveWriter) AddReader(post *models.Post, r io.Reader, size int64, name string) error {
+	if aw.err != nil {
+		return aw.err
+	}
+
+	if aw.format == "tar.gz" && size < 0 {
+		if err := os.MkdirAll(filepath.Dir(aw.base), 0755); err != nil {
+			return err
+		}
+		spool, err := os.CreateTemp(filepath.Dir(aw.base), filepath.Base(aw.base)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if size, err = io.Copy(spool, r); err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = spool
+	}
+	return aw.add(post, r, size, time.Now(), "", name)
+}
+
+// add writes an entry read from r, with source naming the file it comes
+// from, if any
+func (aw *ArchiveWriter) add(post *models.Post, r io.Reader, size int64, modTime time.Time, source, name string) error {
+	if aw.format == "cbz" {
+		name = path.Base(name)
+		ext := path.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		for n := 2; aw.taken(name, source); n++ {
+			name = fmt.Sprintf("%s-%d%s", stem, n, ext)
+		}
+	}
+	if existing, ok := aw.names[name]; ok {
+		if source != "" && existing == source {
+			return nil // Added already
+		}
+		return fmt.Errorf("%s is already in the archive", name)
+	}
+
+	estimate := max(size, 0) + archiveOverhead
+	var entry []byte
+	if post != nil {
+		var err error
+		if entry, err = json.Marshal(manifestEntry{Post: *post, Path: name}); err != nil {
+			return err
+		}
+		estimate += int64(len(entry)) + 2
+	}
+
+	if aw.file != nil && aw.split > 0 && aw.size+estimate > aw.split {
+		aw.err = aw.finishPart()
+	}
+	if aw.err == nil && aw.file == nil {
+		aw.err = aw.startPart()
+	}
+	if aw.err != nil {
+		return aw.err
+	}
+
+	written, readErr, err := aw.addEntry(r, size, modTime, name)
+	if err != nil {
+		aw.err = err
+		return err
+	}
+
+	// A truncated entry still takes up its name and room
+	aw.size += written - max(size, 0) + estimate
+	aw.names[name] = source
+	if readErr != nil {
+		return readErr
+	}
+
+	if source != "" {
+		aw.added = append(aw.added, source)
+	}
+	if entry != nil {
+		aw.manifest = append(aw.manifest, entry)
+		aw.posts = append(aw.posts, manifestEntry{Post: *post, Path: name})
+	}
+	return nil
+}
+
+// taken checks if name holds another file than source
+func (aw *ArchiveWriter) taken(name, source string) bool {
+	existing, ok := aw.names[name]
+	return ok && (source == "" || existing != source)
+}
+
+// Pack adds files of the output directory dir to the archive, with their
+// posts from the library index or metadata sidecars for the manifest. It
+// returns the number of files added.
+func (aw *ArchiveWriter) Pack(dir string, files []string, progressCallback models.ProgressCallback) (int, error) {
+	library, err := OpenLibrary(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the library of %s: %w", dir, err)
+	}
+
+	for i, filePath := range files {
+		relPath, err := library.relative(filePath)
+		if err != nil {
+			return i, err
+		}
+		if err := aw.Add(packedPost(library, relPath, filePath), filePath, relPath); err != nil {
+			return i, fmt.Errorf("failed to add %s: %w", relPath, err)
+		}
+		if progressCallback != nil {
+			progressCallback(i+1, len(files))
+		}
+	}
+	return len(files), nil
+}
+
+// Close finishes the last part and returns the first error of the writer
+func (aw *ArchiveWriter) Close() error {
+	if aw.err == nil && aw.file != nil {
+		aw.err = aw.finishPart()
+	}
+	if aw.file != nil {
+		aw.file.Close()
+		os.Remove(aw.file.Name())
+		aw.file = nil
+	}
+	return aw.err
+}
+
+// Paths returns the completed archives
+func (aw *ArchiveWriter) Paths() []string {
+	return aw.paths
+}
+
+// Archived returns the posts of the completed archives
+func (aw *ArchiveWriter) Archived() []ArchivedPost {
+	var archived []ArchivedPost
+	for part, path := range aw.paths {
+		for _, entry := range aw.archived[part] {
+			archived = append(archived, ArchivedPost{Post: entry.Post, Name: entry.Path, Archive: path})
+		}
+	}
+	return archived
+}
+
+func (aw *ArchiveWriter) partPath(n int) string {
+	return fmt.Sprintf("%s-%03d%s", aw.base, n, archiveExt(aw.format))
+}
+
+func (aw *ArchiveWriter) startPart() error {
+	aw.current = aw.base + archiveExt(aw.format)
+	if len(aw.paths) > 0 {
+		// The first part gets its number once the archive turns out to
+		// be split
+		if len(aw.paths) == 1 {
+			if err := os.Rename(aw.paths[0], aw.partPath(1)); err != nil {
+				return err
+			}
+			aw.paths[0] = aw.partPath(1)
+		}
+		aw.current = aw.partPath(len(aw.paths) + 1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(aw.current), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(aw.current + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	aw.file = file
+	aw.names = make(map[string]string)
+	if aw.format == "tar.gz" {
+		// Media barely compresses, so favor speed
+		aw.gzip, _ = gzip.NewWriterLevel(file, gzip.BestSpeed)
+		aw.tar = tar.NewWriter(aw.gzip)
+	} else {
+		aw.zip = zip.NewWriter(file)
+	}
+	return nil
+}
+
+// addEntry copies r into the current part and returns the bytes written,
+// the error reading r and the error writing the part. A tar entry cut short
+// by a read error is padded to its size so the rest of the part stays
+// readable.
+func (aw *ArchiveWriter) addEntry(r io.Reader, size int64, modTime time.Time, name string) (int64, error, error) {
+	src := &trackedReader{r: r}
+
+	if aw.tar == nil {
+		// Media is already compressed, so store it as-is
+		w, err := aw.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime})
+		if err != nil {
+			return 0, nil, err
+		}
+		written, err := io.Copy(w, src)
+		if src.err != nil {
+			return written, src.err, nil
+		}
+		return written, nil, err
+	}
+
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := aw.tar.WriteHeader(header); err != nil {
+		return 0, nil, err
+	}
+	written, err := io.CopyN(aw.tar, src, size)
+	if err == nil {
+		// Reach the end of r so it can report a damaged download
+		io.Copy(io.Discard, src)
+		return written, src.err, nil
+	}
+	if src.err == nil && err != io.EOF {
+		return written, nil, err
+	}
+
+	readErr := src.err
+	if readErr == nil {
+		readErr = io.ErrUnexpectedEOF
+	}
+	_, err = io.CopyN(aw.tar, zeroReader{}, size-written)
+	return size, readErr, err
+}
+
+// trackedReader keeps the error of its reader apart from those of the
+// writer it is copied to
+type trackedReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// zeroReader reads zero bytes forever
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// finishPart writes the manifest, completes the current part and deletes
+// its files when removing them
+func (aw *ArchiveWriter) finishPart() error {
+	var manifest bytes.Buffer
+	manifest.WriteString("[\n")
+	for i, entry := range aw.manifest {
+		manifest.Write(entry)
+		if i < len(aw.manifest)-1 {
+			manifest.WriteString(",")
+		}
+		manifest.WriteString("\n")
+	}
+	manifest.WriteString("]\n")
+
+	var err error
+	if aw.tar != nil {
+		header := &tar.Header{Name: archiveManifest, Mode: 0644, Size: int64(manifest.Len()), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err = aw.tar.WriteHeader(header); err == nil {
+			_, err = aw.tar.Write(manifest.Bytes())
+		}
+		if err == nil {
+			err = aw.tar.Close()
+		}
+		if err == nil {
+			err = aw.gzip.Close()
+		}
+	} else {
+		var w io.Writer
+		if w, err = aw.zip.CreateHeader(&zip.FileHeader{Name: archiveManifest, Method: zip.Deflate, Modified: time.Now()}); err == nil {
+			_, err = w.Write(manifest.Bytes())
+		}
+		if err == nil {
+			err = aw.zip.Close()
+		}
+	}
+	if closeErr := aw.file.Close(); err == nil {
+		err = closeErr
+	}
+	tmp := aw.file.Name()
+	if err == nil {
+		err = os.Rename(tmp, aw.current)
+	}
+
+	aw.file, aw.zip, aw.gzip, aw.tar = nil, nil, nil, nil
+	added, posts := aw.added, aw.posts
+	aw.size, aw.names, aw.manifest, aw.posts, aw.added = 0, nil, nil, nil, nil
+	if err != nil {
+		// Files are only removed with a complete part, so a broken one
+		// holds nothing that isn't on disk
+		os.Remove(tmp)
+		return err
+	}
+
+	if aw.archived == nil {
+		aw.archived = make(map[int][]manifestEntry)
+	}
+	aw.archived[len(aw.paths)] = posts
+	aw.paths = append(aw.paths, aw.current)
+	if aw.remove {
+		for _, filePath := range added {
+			os.Remove(filePath)
+			os.Remove(filePath + ".json")
+		}
+	}
+	return nil
+}
+
+// packedPost returns the post of a packed file from the library index or its
+// metadata sidecar, or nil when neither knows it
+func packedPost(library *Library, relPath, filePath string) *models.Post {
+	if entry, ok := library.Entry(relPath); ok {
+		return &entry.Post
+	}
+
+	var post models.Post
+	if data, err := os.ReadFile(filePath + ".json"); err == nil && json.Unmarshal(data, &post) == nil && post.ID != "" {
+		return &post
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseCache is the cache used by new clients, set with UseResponseCache
+var responseCache *ResponseCache
+
+// UseResponseCache makes clients created afterwards cache page responses in
+// cache. Nil turns caching off.
+func UseResponseCache(cache *ResponseCache) {
+	responseCache = cache
+}
+
+// ResponseCache stores API list responses and HTML pages on disk, keyed by
+// their normalized URL, so repeated runs don't fetch the same pages again.
+// Media files are never stored.
+type ResponseCache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	// refresh ignores stored responses but still stores new ones
+	refresh bool
+
+	mu   sync.Mutex
+	size int64
+	// scanned is set once size has been measured
+	scanned bool
+}
+
+// CacheInfo describes the contents of a response cache
+type CacheInfo struct {
+	Entries int
+	Size    int64
+	Expired int
+}
+
+// NewResponseCache creates a cache in dir keeping responses for ttl, within
+// maxSize bytes. With refresh set stored responses are fetched again.
+func NewResponseCache(dir string, ttl time.Duration, maxSize int64, refresh bool) *ResponseCache {
+	return &ResponseCache{dir: dir, ttl: ttl, maxSize: maxSize, refresh: refresh}
+}
+
+// Dir returns the directory of the cache
+func (rc *ResponseCache) Dir() string {
+	return rc.dir
+}
+
+// Get returns the stored body of a URL when it is fresh
+func (rc *ResponseCache) Get(rawURL string) ([]byte, bool) {
+	if rc.refresh {
+		return nil, false
+	}
+
+	path := rc.path(rawURL)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > rc.ttl {
+		return nil, false
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// Put stores the body of a URL and evicts the oldest entries once the
+// cache grows past its size limit
+func (rc *ResponseCache) Put(rawURL string, body []byte) error {
+	path := rc.path(rawURL)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, body, 0644); err != nil {
+		return fmt.Errorf("failed to cache response: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !rc.scanned {
+		rc.scanned = true
+		rc.size = rc.scan().Size
+	} else {
+		rc.size += int64(len(body)) - previous
+	}
+	if rc.maxSize > 0 && rc.size > rc.maxSize {
+		rc.prune()
+	}
+	return nil
+}
+
+// Info measures the cache
+func (rc *ResponseCache) Info() CacheInfo {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.scan()
+}
+
+// Clear removes every stored response
+func (rc *ResponseCache) Clear() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.size = 0
+	return os.RemoveAll(rc.dir)
+}
+
+// cacheEntry is a stored response found while scanning the cache
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists the stored responses
+func (rc *ResponseCache) entries() []cacheEntry {
+	var entries []cacheEntry
+	filepath.WalkDir(rc.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	return entries
+}
+
+func (rc *ResponseCache) scan() CacheInfo {
+	var info CacheInfo
+	for _, entry := range rc.entries() {
+		info.Entries++
+		info.Size += entry.size
+		if time.Since(entry.modTime) > rc.ttl {
+			info.Expired++
+		}
+	}
+	return info
+}
+
+// prune removes expired entries, then the oldest ones until the cache is
+// back under 90% of its size limit
+func (rc *ResponseCache) prune() {
+	entries := rc.entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	rc.size = 0
+	for _, entry := range entries {
+		rc.size += entry.size
+	}
+
+	target := rc.maxSize / 10 * 9
+	for _, entry := range entries {
+		expired := time.Since(entry.modTime) > rc.ttl
+		if !expired && rc.size <= target {
+			continue
+		}
+		if os.Remove(entry.path) == nil {
+			rc.size -= entry.size
+		}
+	}
+}
+
+// path returns the file of a URL, spread over subdirectories by the first
+// byte of its key
+func (rc *ResponseCache) path(rawURL string) string {
+	sum := sha256.Sum256([]byte(normalizeURL(rawURL)))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(rc.dir, key[:2], key)
+}
+
+// normalizeURL makes equivalent URLs equal: the scheme and host are
+// lowercased, query parameters sorted and the fragment dropped
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawQuery = u.Query().Encode()
+	return u.String()
+}
+
+// cachedResponse wraps a stored body in a response
+func cachedResponse(rawURL string, body []byte) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Cache": {"HIT"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
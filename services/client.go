@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"r34-go/metrics"
+	"r34-go/utils"
+)
+
+const (
+	defaultTimeout     = 30 * time.Second
+	defaultMinInterval = 100 * time.Millisecond
+)
+
+// RateLimiter spaces out requests so that at most one starts per interval
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter creates a rate limiter allowing one request per interval
+func NewRateLimiter(interval time.Duration) *RateLimiter {
+	return &RateLimiter{interval: interval}
+}
+
+// Wait blocks until the next request is allowed to start
+func (rl *RateLimiter) Wait() {
+	rl.mu.Lock()
+	now := time.Now()
+	wait := rl.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+		rl.next = now
+	}
+	rl.next = rl.next.Add(rl.interval)
+	rl.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Client is an HTTP client shared between services with a common rate limiter
+type Client struct {
+	http    *http.Client
+	limiter *RateLimiter
+
+	// metrics records requests under labels when set
+	metrics *metrics.Registry
+	labels  metrics.Labels
+
+	// cache stores pages fetched with getPage when set
+	cache *ResponseCache
+}
+
+// NewClient creates a client with the default timeout and rate limit
+func NewClient() *Client {
+	return &Client{
+		http: &http.Client{
+			Timeout: defaultTimeout,
+		},
+		limiter: NewRateLimiter(defaultMinInterval),
+		cache:   responseCache,
+	}
+}
+
+// Instrument returns a client sharing the HTTP client and rate limiter of c
+// that records its requests in reg under the given labels
+func (c *Client) Instrument(reg *metrics.Registry, labels metrics.Labels) *Client {
+	instrumented := *c
+	instrumented.metrics = reg
+	instrumented.labels = labels
+	return &instrumented
+}
+
+// Get waits for the rate limiter and issues a GET request
+func (c *Client) Get(url string) (*http.Response, error) {
+	return c.get(url, c.metrics.PageFetch)
+}
+
+// getPage issues a GET request for an API list or HTML page, answering
+// from the response cache when it holds a fresh copy
+func (c *Client) getPage(url string) (*http.Response, error) {
+	if c.cache == nil {
+		return c.Get(url)
+	}
+	if body, ok := c.cache.Get(url); ok {
+		return cachedResponse(url, body), nil
+	}
+
+	resp, err := c.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		c.cache.Put(url, body) // A failed write only costs a refetch
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// getFile issues a GET request for a file download
+func (c *Client) getFile(url string) (*http.Response, error) {
+	return c.get(url, c.metrics.Download)
+}
+
+// get issues a GET request, passing the time until the body is closed to
+// observe when metrics are recorded
+func (c *Client) get(rawURL string, observe func(metrics.Labels, time.Duration)) (*http.Response, error) {
+	c.limiter.Wait()
+	if c.metrics == nil {
+		return c.http.Get(rawURL)
+	}
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	start := time.Now()
+	resp, err := c.http.Get(rawURL)
+	if err != nil {
+		c.metrics.Request(c.labels, host, "error")
+		return nil, err
+	}
+	c.metrics.Request(c.labels, host, strconv.Itoa(resp.StatusCode))
+
+	resp.Body = &meteredBody{
+		ReadCloser: resp.Body,
+		onRead:     func(n int) { c.metrics.Bytes(c.labels, n) },
+		onClose:    func() { observe(c.labels, time.Since(start)) },
+	}
+	return resp, nil
+}
+
+// recordResult counts the outcome of a post and the type of a downloaded file
+func (c *Client) recordResult(result postResult) {
+	switch result.status {
+	case "downloaded":
+		c.metrics.Post(c.labels, result.status)
+		c.metrics.File(c.labels, utils.ClassifyFileType(filepath.Ext(result.file)))
+	case "skipped", "failed":
+		c.metrics.Post(c.labels, result.status)
+	}
+}
+
+// recordRetry counts a retried download
+func (c *Client) recordRetry() {
+	c.metrics.Retry(c.labels)
+}
+
+// meteredBody reports the bytes read from a response body and when it is
+// closed
+type meteredBody struct {
+	io.ReadCloser
+	onRead  func(n int)
+	onClose func()
+	closed  bool
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.onRead(n)
+	}
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.onClose()
+	}
+	return b.ReadCloser.Close()
+}
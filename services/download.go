@@ -1,31 +1,56 @@
 package services
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+const (
+	// bytesInterval is how many bytes are written between progress reports
+	bytesInterval = 256 * 1024
+	// downloadRetries is how many times a failed file request is retried,
+	// waiting retryDelay longer each time
+	downloadRetries = 2
+	retryDelay      = time.Second
+)
+
+// ByteCallback receives the number of bytes written so far and the expected
+// size of the file, or 0 when the size is unknown
+type ByteCallback func(written, size int64)
+
 // DownloadService handles file downloads
 type DownloadService struct {
-	client *http.Client
+	client *Client
 }
 
 // NewDownloadService creates a new download service instance
 func NewDownloadService() *DownloadService {
+	return NewDownloadServiceWithClient(NewClient())
+}
+
+// NewDownloadServiceWithClient creates a new download service instance sharing the given client
+func NewDownloadServiceWithClient(client *Client) *DownloadService {
 	return &DownloadService{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		client: client,
 	}
 }
 
 // Download downloads a file from URL and saves it to the specified path
 // Returns an error if the download fails, nil if successful or file already exists
 func (ds *DownloadService) Download(url, filePath string) error {
+	return ds.DownloadWithProgress(url, filePath, nil)
+}
+
+// DownloadWithProgress downloads a file like Download, reporting the bytes
+// written to onBytes
+func (ds *DownloadService) DownloadWithProgress(url, filePath string, onBytes ByteCallback) error {
 	// Check if file already exists
 	if _, err := os.Stat(filePath); err == nil {
 		return fmt.Errorf("file already exists") // Special error to indicate file exists
@@ -37,40 +62,145 @@ func (ds *DownloadService) Download(url, filePath string) error {
 		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 	
-	// Make HTTP request
-	resp, err := ds.client.Get(url)
+	resp, err := ds.fetch(url)
 	if err != nil {
-		return fmt.Errorf("failed to download from %s: %w", url, err)
+		return err
 	}
 	defer resp.Body.Close()
 	
-	// Check if request was successful
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	return writeFile(resp, filePath, "", onBytes)
+}
+
+// fetch requests a file, failing on any status but 200 OK. Network errors,
+// rate limits and server errors are retried up to downloadRetries times.
+func (ds *DownloadService) fetch(url string) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= downloadRetries; attempt++ {
+		if attempt > 0 {
+			ds.client.recordRetry()
+			time.Sleep(time.Duration(attempt) * retryDelay)
+		}
+
+		resp, err := ds.client.getFile(url)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to download from %s: %w", url, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		lastErr = fmt.Errorf("bad status: %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break
+		}
 	}
-	
-	// Create the file
-	file, err := os.Create(filePath)
+	return nil, lastErr
+}
+
+// copyBody writes a response body to dst, reporting the bytes written to
+// onBytes when set
+func copyBody(dst io.Writer, resp *http.Response, onBytes ByteCallback) error {
+	var progress *progressWriter
+	if onBytes != nil {
+		progress = &progressWriter{size: resp.ContentLength, onBytes: onBytes}
+		dst = io.MultiWriter(dst, progress)
+	}
+	_, err := io.Copy(dst, resp.Body)
+	if progress != nil {
+		progress.finish()
+	}
+	return err
+}
+
+// DownloadToStore downloads a file into the content store, if it isn't stored
+// yet, and links filePath to it. hash must be the MD5 of the file at url, it
+// is checked before the file enters the store. Without a store or MD5 it
+// behaves like Download.
+func (ds *DownloadService) DownloadToStore(url, filePath, hash string, store *ContentStore) error {
+	return ds.DownloadToStoreWithProgress(url, filePath, hash, store, nil)
+}
+
+// DownloadToStoreWithProgress downloads a file like DownloadToStore,
+// reporting the bytes written to onBytes
+func (ds *DownloadService) DownloadToStoreWithProgress(url, filePath, hash string, store *ContentStore, onBytes ByteCallback) error {
+	if store == nil || hash == "" {
+		return ds.DownloadWithProgress(url, filePath, onBytes)
+	}
+
+	if _, err := os.Lstat(filePath); err == nil {
+		return fmt.Errorf("file already exists")
+	}
+
+	// Another job may store the same file meanwhile, which is fine as
+	// both write the same content
+	storePath := store.Path(hash, filepath.Ext(filePath))
+	if !store.Has(hash, filepath.Ext(filePath)) {
+		if err := ds.downloadToStore(url, storePath, hash, onBytes); err != nil {
+			return err
+		}
+	}
+
+	if err := store.Link(storePath, filePath); err != nil {
+		return fmt.Errorf("failed to link %s to content store: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// downloadToStore saves a file at storePath, checked against its MD5 so a
+// damaged download never ends up in the store
+func (ds *DownloadService) downloadToStore(url, storePath, hash string, onBytes ByteCallback) error {
+	dir := filepath.Dir(storePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	resp, err := ds.fetch(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return writeFile(resp, storePath, hash, onBytes)
+}
+
+// writeFile saves a response body at filePath through a temporary file that
+// is renamed once complete, so an interrupted download never leaves a
+// partial file behind. The MD5 of the body is checked when hash is set.
+func writeFile(resp *http.Response, filePath, hash string, onBytes ByteCallback) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
 	if err != nil {
 		return fmt.Errorf("failed to create file %s: %w", filePath, err)
 	}
-	defer file.Close()
-	
-	// Copy the response body to file
-	_, err = io.Copy(file, resp.Body)
+	defer os.Remove(tmp.Name())
+	// Temporary files are private, saved files are not
+	tmp.Chmod(0644)
+
+	hasher := md5.New()
+	err = copyBody(io.MultiWriter(tmp, hasher), resp, onBytes)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		// If copy failed, remove the partial file
-		os.Remove(filePath)
 		return fmt.Errorf("failed to write file %s: %w", filePath, err)
 	}
-	
-	return nil
+
+	if sum := hex.EncodeToString(hasher.Sum(nil)); hash != "" && sum != strings.ToLower(hash) {
+		return fmt.Errorf("md5 mismatch for %s: got %s, expected %s", resp.Request.URL, sum, hash)
+	}
+
+	return os.Rename(tmp.Name(), filePath)
 }
 
 // DownloadWithRetry downloads a file with retry logic
 func (ds *DownloadService) DownloadWithRetry(url, filePath string, maxRetries int) error {
 	var lastErr error
 	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			ds.client.recordRetry()
+		}
 		if err := ds.Download(url, filePath); err != nil {
 			// If file already exists, don't retry
 			if err.Error() == "file already exists" {
@@ -89,3 +219,29 @@ func (ds *DownloadService) DownloadWithRetry(url, filePath string, maxRetries in
 	}
 	return lastErr
 }
+
+// progressWriter counts the bytes of a download and reports them every
+// bytesInterval bytes
+type progressWriter struct {
+	written  int64
+	reported int64
+	size     int64
+	onBytes  ByteCallback
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.written += int64(len(p))
+	if pw.written-pw.reported >= bytesInterval {
+		pw.reported = pw.written
+		pw.onBytes(pw.written, pw.size)
+	}
+	return len(p), nil
+}
+
+// finish reports the bytes written since the last report
+func (pw *progressWriter) finish() {
+	if pw.written != pw.reported {
+		pw.reported = pw.written
+		pw.onBytes(pw.written, pw.size)
+	}
+}
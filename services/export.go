@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"r34-go/models"
+)
+
+// ExportFormats lists the formats supported by Exporter
+var ExportFormats = []string{"csv", "jsonl", "aria2", "urls"}
+
+// csvHeader names the columns of the csv format
+var csvHeader = []string{"id", "url", "path", "md5", "rating", "score", "width", "height", "created_at", "tags"}
+
+// Exporter writes posts as records for other downloaders, one per post.
+// Paths follow the layout and filename template of downloads.
+type Exporter struct {
+	w      *bufio.Writer
+	csv    *csv.Writer
+	format string
+	// dir is written to aria2 entries as the download directory when set
+	dir string
+}
+
+// exportRecord is a post in the jsonl format
+type exportRecord struct {
+	models.Post
+	DownloadURL string `json:"download_url"`
+	Path        string `json:"path"`
+}
+
+// NewExporter creates an exporter writing format to w
+func NewExporter(w io.Writer, format string) (*Exporter, error) {
+	e := &Exporter{w: bufio.NewWriter(w), format: format}
+	switch format {
+	case "csv":
+		e.csv = csv.NewWriter(e.w)
+		if err := e.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	case "jsonl", "aria2", "urls":
+	default:
+		return nil, fmt.Errorf("unknown export format %q (expected %s)", format, strings.Join(ExportFormats, ", "))
+	}
+	return e, nil
+}
+
+// SetDir sets the download directory of aria2 entries
+func (e *Exporter) SetDir(dir string) {
+	e.dir = dir
+}
+
+// write writes the record of a post saved as file
+func (e *Exporter) write(post models.Post, file postFile) error {
+	relPath := path.Join(file.folder, file.name)
+
+	switch e.format {
+	case "csv":
+		return e.csv.Write([]string{
+			post.ID, file.url, relPath, post.MD5, post.Rating,
+			strconv.Itoa(post.Score), strconv.Itoa(post.Width), strconv.Itoa(post.Height),
+			post.CreatedAt, post.Tags,
+		})
+	case "jsonl":
+		data, err := json.Marshal(exportRecord{Post: post, DownloadURL: file.url, Path: relPath})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(e.w, "%s\n", data)
+		return err
+	case "aria2":
+		fmt.Fprintf(e.w, "%s\n  out=%s\n", file.url, relPath)
+		if e.dir != "" {
+			fmt.Fprintf(e.w, "  dir=%s\n", e.dir)
+		}
+		// Sample videos are different files than the one the MD5 is of
+		if post.MD5 != "" && file.url == post.FileURL {
+			fmt.Fprintf(e.w, "  checksum=md5=%s\n", post.MD5)
+		}
+		return nil
+	default:
+		_, err := fmt.Fprintln(e.w, file.url)
+		return err
+	}
+}
+
+// Flush writes any buffered records
+func (e *Exporter) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// ExportContent pages through the posts of tags and writes up to quantity
+// of them to exporter. Posts failing the filters or of a disabled file type
+// are left out and don't count towards the quantity.
+func (as *APIService) ExportContent(exporter *Exporter, tags string, quantity uint16, progressCallback models.ProgressCallback) (int, error) {
+	exported := 0
+
+	for pid := 0; exported < int(quantity); pid++ {
+		url := apiRequest("post").tags(tags).setInt("pid", pid).String()
+
+		resp, err := as.client.getPage(url)
+		if err != nil {
+			return exported, fmt.Errorf("failed to fetch page %d: %w", pid, err)
+		}
+
+		var apiResp models.APIResponse
+		if err := xml.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+			resp.Body.Close()
+			return exported, fmt.Errorf("failed to decode XML for page %d: %w", pid, err)
+		}
+		resp.Body.Close()
+
+		as.options.emit(models.Event{Type: models.EventPageFetched, Page: pid, Posts: len(apiResp.Posts)})
+		if len(apiResp.Posts) == 0 {
+			break
+		}
+
+		as.categorize(apiResp.Posts)
+
+		for _, post := range apiResp.Posts {
+			if err := as.options.canceled(); err != nil {
+				return exported, err
+			}
+			if post.FileURL == "" || !as.options.matches(post) {
+				continue
+			}
+			file, disabled := as.options.fileFor(post)
+			if disabled != "" {
+				continue
+			}
+
+			if err := exporter.write(post, file); err != nil {
+				return exported, fmt.Errorf("failed to write post %s: %w", post.ID, err)
+			}
+			exported++
+			if progressCallback != nil {
+				progressCallback(exported, int(quantity))
+			}
+			if exported >= int(quantity) {
+				break
+			}
+		}
+
+		// A short page is the last one
+		if len(apiResp.Posts) < pageSize {
+			break
+		}
+	}
+
+	return exported, exporter.Flush()
+}
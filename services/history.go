@@ -0,0 +1,79 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"r34-go/config"
+)
+
+const (
+	historyFile  = "query_history.json"
+	historyLimit = 200
+)
+
+// QueryHistory keeps the most recently used tag queries, newest first
+type QueryHistory struct {
+	path    string
+	queries []string
+}
+
+// LoadQueryHistory reads the query history. A missing or damaged history
+// file gives an empty history.
+func LoadQueryHistory() *QueryHistory {
+	history := &QueryHistory{path: filepath.Join(config.DataDir(), historyFile)}
+
+	if data, err := os.ReadFile(history.path); err == nil {
+		json.Unmarshal(data, &history.queries)
+	}
+
+	return history
+}
+
+// Add moves a query to the front of the history
+func (h *QueryHistory) Add(query string) {
+	query = strings.Join(strings.Fields(query), " ")
+	if query == "" {
+		return
+	}
+
+	queries := []string{query}
+	for _, q := range h.queries {
+		if q != query && len(queries) < historyLimit {
+			queries = append(queries, q)
+		}
+	}
+	h.queries = queries
+}
+
+// Matching returns the queries starting with prefix, newest first
+func (h *QueryHistory) Matching(prefix string) []string {
+	var matches []string
+	for _, q := range h.queries {
+		if strings.HasPrefix(q, prefix) {
+			matches = append(matches, q)
+		}
+	}
+	return matches
+}
+
+// Save writes the history to disk
+func (h *QueryHistory) Save() error {
+	data, err := json.Marshal(h.queries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(h.path, data, 0644)
+}
+
+// RecordQuery adds a query to the saved history
+func RecordQuery(query string) error {
+	history := LoadQueryHistory()
+	history.Add(query)
+	return history.Save()
+}
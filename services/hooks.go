@@ -0,0 +1,280 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+const (
+	hookTimeout = 30 * time.Second
+	// hookFileLimit is the number of files listed in chat notifications
+	hookFileLimit = 10
+)
+
+// Notifier delivers job reports to the configured hooks
+type Notifier struct {
+	hooks  []config.HookSettings
+	client *http.Client
+}
+
+// NewNotifier creates a notifier for the given hooks
+func NewNotifier(hooks []config.HookSettings) *Notifier {
+	return &Notifier{
+		hooks:  hooks,
+		client: &http.Client{Timeout: hookTimeout},
+	}
+}
+
+// ValidateHook checks that a hook has a target and a known format
+func ValidateHook(hook config.HookSettings) error {
+	if (hook.URL == "") == (hook.Command == "") {
+		return fmt.Errorf("hook needs either a url or a command")
+	}
+	switch hook.Format {
+	case "", "json", "discord", "ntfy":
+	default:
+		return fmt.Errorf("unknown hook format %q (expected json, discord or ntfy)", hook.Format)
+	}
+	for _, on := range hook.On {
+		if on != "done" && on != "failed" {
+			return fmt.Errorf("unknown hook event %q (expected done or failed)", on)
+		}
+	}
+	return nil
+}
+
+// NewJobReport builds the report of a finished job
+func NewJobReport(job models.Job, stats *models.DownloadStats, err error, files []string, started time.Time) models.JobReport {
+	report := models.JobReport{
+		Event:    "done",
+		Job:      job.DisplayName(),
+		Query:    job.Tags,
+		Output:   job.Output,
+		Files:    files,
+		Started:  started,
+		Finished: time.Now(),
+	}
+	if report.Files == nil {
+		report.Files = []string{}
+	}
+	if stats != nil {
+		report.Stats = *stats
+	}
+	if err != nil {
+		report.Event = "failed"
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// Notify delivers the report to every hook listening for its event and
+// returns the errors of the hooks that failed
+func (n *Notifier) Notify(report models.JobReport) error {
+	var errs []error
+	for _, hook := range n.hooks {
+		if !listensFor(hook, report.Event) {
+			continue
+		}
+
+		var err error
+		if hook.Command != "" {
+			err = n.runCommand(hook, report)
+		} else {
+			err = n.post(hook, report)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hook %s: %w", hookName(hook), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) post(hook config.HookSettings, report models.JobReport) error {
+	var req *http.Request
+	var err error
+
+	switch hook.Format {
+	case "discord":
+		req, err = newJSONRequest(hook.URL, discordPayload(report))
+	case "ntfy":
+		req, err = http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(reportMessage(report)))
+		if err == nil {
+			req.Header.Set("Title", reportTitle(report))
+			if report.Event == "failed" {
+				req.Header.Set("Tags", "x")
+				req.Header.Set("Priority", "high")
+			} else {
+				req.Header.Set("Tags", "white_check_mark")
+			}
+		}
+	default:
+		req, err = newJSONRequest(hook.URL, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return nil
+}
+
+// runCommand runs a hook command with the report in R34_HOOK_* environment
+// variables and as JSON on stdin
+func (n *Notifier) runCommand(hook config.HookSettings, report models.JobReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"R34_HOOK_EVENT="+report.Event,
+		"R34_HOOK_JOB="+report.Job,
+		"R34_HOOK_QUERY="+report.Query,
+		"R34_HOOK_OUTPUT="+report.Output,
+		"R34_HOOK_ERROR="+report.Error,
+		"R34_HOOK_TOTAL="+strconv.Itoa(report.Stats.Total),
+		"R34_HOOK_DOWNLOADED="+strconv.Itoa(report.Stats.Downloaded),
+		"R34_HOOK_SKIPPED="+strconv.Itoa(report.Stats.Skipped),
+		"R34_HOOK_FAILED="+strconv.Itoa(report.Stats.Failed),
+		"R34_HOOK_IMAGES="+strconv.Itoa(report.Stats.Images),
+		"R34_HOOK_GIFS="+strconv.Itoa(report.Stats.Gifs),
+		"R34_HOOK_VIDEOS="+strconv.Itoa(report.Stats.Videos),
+		"R34_HOOK_FILES="+strings.Join(report.Files, "\n"),
+	)
+
+	return cmd.Run()
+}
+
+func newJSONRequest(url string, payload any) (*http.Request, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// discordPayload formats a report as a Discord webhook message
+func discordPayload(report models.JobReport) map[string]any {
+	color := 0x2ecc71
+	if report.Event == "failed" {
+		color = 0xe74c3c
+	}
+
+	fields := []map[string]any{
+		{"name": "Downloaded", "value": strconv.Itoa(report.Stats.Downloaded), "inline": true},
+		{"name": "Skipped", "value": strconv.Itoa(report.Stats.Skipped), "inline": true},
+		{"name": "Failed", "value": strconv.Itoa(report.Stats.Failed), "inline": true},
+	}
+	if report.Error != "" {
+		fields = append(fields, map[string]any{"name": "Error", "value": utils.TruncateString(report.Error, 1024)})
+	}
+
+	embed := map[string]any{
+		"title":     utils.TruncateString(reportTitle(report), 256),
+		"color":     color,
+		"fields":    fields,
+		"timestamp": report.Finished.Format(time.RFC3339),
+	}
+	// Discord rejects empty descriptions
+	if files := reportFiles(report); files != "" {
+		embed["description"] = utils.TruncateString(files, 4096)
+	}
+
+	return map[string]any{
+		"username": "r34-go",
+		"embeds":   []map[string]any{embed},
+	}
+}
+
+func reportTitle(report models.JobReport) string {
+	if report.Event == "failed" {
+		return "Job failed: " + report.Job
+	}
+	return "Job done: " + report.Job
+}
+
+// reportMessage is the plain text body of a notification
+func reportMessage(report models.JobReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Query: %s\n", report.Query)
+	fmt.Fprintf(&b, "Downloaded %d, skipped %d, failed %d\n", report.Stats.Downloaded, report.Stats.Skipped, report.Stats.Failed)
+	if report.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", report.Error)
+	}
+	if files := reportFiles(report); files != "" {
+		b.WriteString(files)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// reportFiles lists the first new files of a report
+func reportFiles(report models.JobReport) string {
+	var b strings.Builder
+	for i, file := range report.Files {
+		if i == hookFileLimit {
+			fmt.Fprintf(&b, "... and %d more\n", len(report.Files)-hookFileLimit)
+			break
+		}
+		b.WriteString(file + "\n")
+	}
+	return b.String()
+}
+
+func listensFor(hook config.HookSettings, event string) bool {
+	if len(hook.On) == 0 {
+		return true
+	}
+	for _, on := range hook.On {
+		if on == event {
+			return true
+		}
+	}
+	return false
+}
+
+func hookName(hook config.HookSettings) string {
+	if hook.Command != "" {
+		return hook.Command
+	}
+	return hook.URL
+}
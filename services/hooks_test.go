@@ -0,0 +1,248 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"r34-go/config"
+	"r34-go/models"
+)
+
+// hookRequest is a request received by a test hook server
+type hookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// hookServer records the requests it receives and answers with status
+func hookServer(t *testing.T, status int) (*httptest.Server, *[]hookRequest) {
+	t.Helper()
+	var requests []hookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("hook method = %s, want POST", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, hookRequest{header: r.Header, body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func testReport(event string) models.JobReport {
+	job := models.Job{Name: "hutao", Tags: "hu_tao_(genshin_impact)", Output: "downloads"}
+	stats := &models.DownloadStats{Total: 3, Downloaded: 2, Skipped: 1}
+	var err error
+	if event == "failed" {
+		err = errors.New("rate limited")
+	}
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return NewJobReport(job, stats, err, []string{"downloads/Images/1.png", "downloads/Images/2.png"}, started)
+}
+
+// notify sends report to a single hook of the given format and returns the
+// request it received
+func notify(t *testing.T, format string, report models.JobReport) hookRequest {
+	t.Helper()
+	server, requests := hookServer(t, http.StatusOK)
+	notifier := NewNotifier([]config.HookSettings{{URL: server.URL, Format: format}})
+	if err := notifier.Notify(report); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("hook received %d requests, want 1", len(*requests))
+	}
+	return (*requests)[0]
+}
+
+func TestNotifyJSON(t *testing.T) {
+	report := testReport("done")
+	req := notify(t, "json", report)
+
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var got models.JobReport
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("payload is not a job report: %v", err)
+	}
+	if got.Event != "done" || got.Job != "hutao" || got.Query != report.Query || got.Output != "downloads" {
+		t.Errorf("payload = %+v, want the fields of %+v", got, report)
+	}
+	if got.Stats.Downloaded != 2 || got.Stats.Skipped != 1 || len(got.Files) != 2 || got.Error != "" {
+		t.Errorf("payload stats and files = %+v %v %q", got.Stats, got.Files, got.Error)
+	}
+}
+
+func TestNotifyDiscord(t *testing.T) {
+	tests := []struct {
+		event      string
+		title      string
+		color      float64
+		fieldCount int
+	}{
+		{"done", "Job done: hutao", 0x2ecc71, 3},
+		{"failed", "Job failed: hutao", 0xe74c3c, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			req := notify(t, "discord", testReport(tt.event))
+
+			if got := req.header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var payload struct {
+				Username string `json:"username"`
+				Embeds   []struct {
+					Title       string           `json:"title"`
+					Description string           `json:"description"`
+					Color       float64          `json:"color"`
+					Fields      []map[string]any `json:"fields"`
+					Timestamp   string           `json:"timestamp"`
+				} `json:"embeds"`
+			}
+			if err := json.Unmarshal(req.body, &payload); err != nil {
+				t.Fatalf("payload is not JSON: %v", err)
+			}
+			if payload.Username != "r34-go" || len(payload.Embeds) != 1 {
+				t.Fatalf("payload = %s", req.body)
+			}
+
+			embed := payload.Embeds[0]
+			if embed.Title != tt.title {
+				t.Errorf("title = %q, want %q", embed.Title, tt.title)
+			}
+			if embed.Color != tt.color {
+				t.Errorf("color = %#x, want %#x", int(embed.Color), int(tt.color))
+			}
+			if len(embed.Fields) != tt.fieldCount {
+				t.Errorf("got %d fields, want %d", len(embed.Fields), tt.fieldCount)
+			}
+			if embed.Description != "downloads/Images/1.png\ndownloads/Images/2.png\n" {
+				t.Errorf("description = %q", embed.Description)
+			}
+			if _, err := time.Parse(time.RFC3339, embed.Timestamp); err != nil {
+				t.Errorf("timestamp %q is not RFC 3339", embed.Timestamp)
+			}
+		})
+	}
+}
+
+func TestNotifyNtfy(t *testing.T) {
+	tests := []struct {
+		event    string
+		title    string
+		tags     string
+		priority string
+		body     string
+	}{
+		{"done", "Job done: hutao", "white_check_mark", "",
+			"Query: hu_tao_(genshin_impact)\nDownloaded 2, skipped 1, failed 0\ndownloads/Images/1.png\ndownloads/Images/2.png"},
+		{"failed", "Job failed: hutao", "x", "high",
+			"Query: hu_tao_(genshin_impact)\nDownloaded 2, skipped 1, failed 0\nError: rate limited\ndownloads/Images/1.png\ndownloads/Images/2.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			req := notify(t, "ntfy", testReport(tt.event))
+
+			if got := req.header.Get("Title"); got != tt.title {
+				t.Errorf("Title = %q, want %q", got, tt.title)
+			}
+			if got := req.header.Get("Tags"); got != tt.tags {
+				t.Errorf("Tags = %q, want %q", got, tt.tags)
+			}
+			if got := req.header.Get("Priority"); got != tt.priority {
+				t.Errorf("Priority = %q, want %q", got, tt.priority)
+			}
+			if got := string(req.body); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestNotifyFileLimit(t *testing.T) {
+	report := testReport("done")
+	report.Files = nil
+	for i := 0; i < hookFileLimit+5; i++ {
+		report.Files = append(report.Files, "file")
+	}
+
+	req := notify(t, "ntfy", report)
+	if !strings.HasSuffix(string(req.body), "... and 5 more") {
+		t.Errorf("body = %q, want the list cut after %d files", req.body, hookFileLimit)
+	}
+}
+
+func TestNotifyEventsAndErrors(t *testing.T) {
+	server, requests := hookServer(t, http.StatusInternalServerError)
+	notifier := NewNotifier([]config.HookSettings{
+		{URL: server.URL, On: []string{"failed"}},
+	})
+
+	if err := notifier.Notify(testReport("done")); err != nil {
+		t.Errorf("Notify for an unwatched event: %v", err)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("hook listening for failed received a done report")
+	}
+
+	err := notifier.Notify(testReport("failed"))
+	if err == nil || !strings.Contains(err.Error(), "bad status: 500") {
+		t.Errorf("Notify error = %v, want the bad status", err)
+	}
+}
+
+func TestNotifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook command uses sh")
+	}
+	t.Setenv("R34_OUTPUT", "") // Restored after the test
+	os.Unsetenv("R34_OUTPUT")
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	stdinFile := filepath.Join(dir, "stdin")
+	command := `printf '%s|%s|%s|%s|%s' "$R34_HOOK_EVENT" "$R34_HOOK_JOB" "$R34_HOOK_OUTPUT" "$R34_HOOK_DOWNLOADED" "${R34_OUTPUT-unset}" > ` +
+		envFile + ` && cat > ` + stdinFile
+	notifier := NewNotifier([]config.HookSettings{{Command: command}})
+
+	report := testReport("done")
+	if err := notifier.Notify(report); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("hook command did not run: %v", err)
+	}
+	// R34_OUTPUT would override the output setting of an r34-go run by the hook
+	if want := "done|hutao|downloads|2|unset"; string(env) != want {
+		t.Errorf("hook environment = %q, want %q", env, want)
+	}
+
+	stdin, err := os.ReadFile(stdinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got models.JobReport
+	if err := json.Unmarshal(stdin, &got); err != nil || got.Job != "hutao" || len(got.Files) != 2 {
+		t.Errorf("hook stdin = %s, want the job report (%v)", stdin, err)
+	}
+
+	failing := NewNotifier([]config.HookSettings{{Command: "exit 3"}})
+	if err := failing.Notify(report); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Notify error = %v, want the exit status", err)
+	}
+}
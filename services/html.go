@@ -6,41 +6,48 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"r34-go/config"
 	"r34-go/models"
 	"r34-go/utils"
 )
 
 const (
-	contentURL     = "https://rule34.xxx/index.php?page=post&s=list&tags="
-	htmlPageSize   = 42
-	rule34BaseURL  = "https://rule34.xxx/"
+	htmlPageSize  = 42
+	rule34BaseURL = "https://rule34.xxx/"
 )
 
 // HTMLService handles HTML parsing and downloading
 type HTMLService struct {
-	client          *http.Client
+	client          *Client
 	downloadService *DownloadService
+	options         DownloadOptions
+	output          *outputState
 }
 
 // NewHTMLService creates a new HTML service instance
 func NewHTMLService() *HTMLService {
+	return NewHTMLServiceWithClient(NewClient())
+}
+
+// NewHTMLServiceWithClient creates a new HTML service instance sharing the given client
+func NewHTMLServiceWithClient(client *Client) *HTMLService {
 	return &HTMLService{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		downloadService: NewDownloadService(),
+		client:          client,
+		downloadService: NewDownloadServiceWithClient(client),
+		options:         OptionsFromSettings(),
 	}
 }
 
+// SetOptions overrides the file type switches used for downloads.
+// Filters are not applied since list pages carry no post metadata.
+func (hs *HTMLService) SetOptions(options DownloadOptions) {
+	hs.options = options
+}
+
 // IsSomethingFound checks if there's any content for the specified tags
 func (hs *HTMLService) IsSomethingFound(tags string) (bool, error) {
-	url := fmt.Sprintf("%s%s", contentURL, tags)
-	
-	doc, err := hs.loadHTMLDocument(url)
+	doc, err := hs.loadHTMLDocument(listRequest(tags, 0))
 	if err != nil {
 		return false, err
 	}
@@ -52,9 +59,7 @@ func (hs *HTMLService) IsSomethingFound(tags string) (bool, error) {
 
 // GetMaxPid returns the maximum page number for the specified tags
 func (hs *HTMLService) GetMaxPid(tags string) (int, error) {
-	url := fmt.Sprintf("%s%s", contentURL, tags)
-	
-	doc, err := hs.loadHTMLDocument(url)
+	doc, err := hs.loadHTMLDocument(listRequest(tags, 0))
 	if err != nil {
 		return 0, err
 	}
@@ -86,9 +91,7 @@ func (hs *HTMLService) GetMaxPid(tags string) (int, error) {
 
 // GetCountContent returns the amount of content on the specified page
 func (hs *HTMLService) GetCountContent(tags string, pid int) (int, error) {
-	url := fmt.Sprintf("%s%s&pid=%d", contentURL, tags, pid)
-	
-	doc, err := hs.loadHTMLDocument(url)
+	doc, err := hs.loadHTMLDocument(listRequest(tags, pid))
 	if err != nil {
 		return -1, err
 	}
@@ -106,8 +109,16 @@ func (hs *HTMLService) GetCountContent(tags string, pid int) (int, error) {
 }
 
 // DownloadContent downloads content using HTML parsing method
-func (hs *HTMLService) DownloadContent(path, tags string, quantity uint16, progressCallback models.ProgressCallback) (*models.DownloadStats, error) {
-	stats := &models.DownloadStats{Total: int(quantity)}
+func (hs *HTMLService) DownloadContent(path, tags string, quantity uint16, progressCallback models.ProgressCallback) (stats *models.DownloadStats, err error) {
+	stats = &models.DownloadStats{Total: int(quantity)}
+
+	hs.output = openOutput(hs.options, path)
+	defer func() {
+		if closeErr := hs.output.close(stats); err == nil {
+			err = closeErr
+		}
+		hs.output = nil
+	}()
 	
 	maxPages := int(quantity)
 	residue := htmlPageSize
@@ -118,9 +129,7 @@ func (hs *HTMLService) DownloadContent(path, tags string, quantity uint16, progr
 	}
 
 	for pid := 0; pid < maxPages; pid += htmlPageSize {
-		url := fmt.Sprintf("%s%s&pid=%d", contentURL, tags, pid)
-		
-		doc, err := hs.loadHTMLDocument(url)
+		doc, err := hs.loadHTMLDocument(listRequest(tags, pid))
 		if err != nil {
 			return stats, fmt.Errorf("failed to load page at PID %d: %w", pid, err)
 		}
@@ -135,6 +144,8 @@ func (hs *HTMLService) DownloadContent(path, tags string, quantity uint16, progr
 			}
 		})
 
+		hs.options.emit(models.Event{Type: models.EventPageFetched, Page: pid / htmlPageSize, Posts: len(posts)})
+
 		if len(posts) == 0 {
 			break // No more posts found
 		}
@@ -145,6 +156,7 @@ func (hs *HTMLService) DownloadContent(path, tags string, quantity uint16, progr
 		}
 	}
 
+	hs.options.emit(models.Event{Type: models.EventRunFinished, Stats: stats})
 	return stats, nil
 }
 
@@ -157,54 +169,88 @@ func (hs *HTMLService) downloadPosts(posts []string, path string, pid, residue,
 	}
 
 	for i := 0; i < maxPosts && i < len(posts); i++ {
+		if err := hs.options.canceled(); err != nil {
+			return err
+		}
+
 		postURL := rule34BaseURL + posts[i]
+		reportStatus := pid + i + 1
 		
 		doc, err := hs.loadHTMLDocument(postURL)
 		if err != nil {
 			stats.Failed++
+			hs.options.emit(models.Event{Type: models.EventFileFailed, URL: postURL, Reason: err.Error(), Current: reportStatus, Total: totalQuantity})
+			hs.client.recordResult(postResult{status: "failed"})
 			continue
 		}
+		post := hs.parsePostPage(doc, postURL)
+
+		// Post pages show tags, rating and score, enough for the filters
+		// and the local part of the query
+		if !hs.options.matches(post) {
+			hs.options.emit(models.Event{Type: models.EventPostSkipped, PostID: post.ID, Reason: "filtered"})
+			continue
+		}
+
+		var result postResult
 
 		// Check for video first
 		videoSrc, videoExists := doc.Find("video#gelcomVideoPlayer source").Attr("src")
-		if videoExists && config.AppSettings.Video {
+		if videoExists && hs.options.Video {
 			filename := hs.extractFilename(videoSrc)
 			filePath := filepath.Join(path, "Video", filename)
-			
-			if err := hs.downloadService.Download(videoSrc, filePath); err != nil {
+
+			post.FileURL = videoSrc
+			post.MD5 = utils.ExtractMD5FromURL(videoSrc)
+			onBytes := hs.options.byteProgress(post, videoSrc, filePath)
+			if _, err := hs.output.save(hs.downloadService, post, videoSrc, filePath, onBytes); err != nil {
 				stats.Failed++
+				result = postResult{status: "failed", file: filePath, reason: err.Error()}
 			} else {
 				stats.Videos++
 				stats.Downloaded++
+				result = postResult{status: "downloaded", file: filePath}
 			}
 		} else {
 			// Check for image
 			imageSrc, imageExists := doc.Find("div.content img#image").Attr("src")
 			if imageExists {
-				err := hs.downloadImage(imageSrc, path, stats)
-				if err != nil {
+				file, warning, err := hs.downloadImage(imageSrc, path, post, stats)
+				if err != nil && err.Error() == "near duplicate" {
+					stats.Skipped++
+					result = postResult{status: "skipped", file: file, reason: err.Error()}
+				} else if err != nil {
 					stats.Failed++
+					result = postResult{status: "failed", file: file, reason: err.Error()}
+				} else if file == "" {
+					stats.Downloaded++
+					result = postResult{status: "disabled", reason: "file type disabled"}
 				} else {
 					stats.Downloaded++
+					result = postResult{status: "downloaded", file: file, reason: warning}
 				}
 			} else {
 				stats.Failed++
+				result = postResult{status: "failed", reason: "no media found on post page"}
 			}
 		}
 
-		reportStatus := pid + i + 1
+		hs.options.emitResult(post, result, reportStatus, totalQuantity)
+		hs.client.recordResult(result)
+		hs.options.reportStats(stats)
+
 		if progressCallback != nil {
 			progressCallback(reportStatus, totalQuantity)
 		}
-
-		// Small delay to avoid overwhelming the server
-		time.Sleep(100 * time.Millisecond)
 	}
 
 	return nil
 }
 
-func (hs *HTMLService) downloadImage(imageSrc, path string, stats *models.DownloadStats) error {
+// downloadImage downloads the image or GIF of a post page and returns the
+// path it was saved to, which is empty when the file type is disabled, and
+// why a kept image went without the near-duplicate check
+func (hs *HTMLService) downloadImage(imageSrc, path string, post models.Post, stats *models.DownloadStats) (string, string, error) {
 	// Extract ID from URL query parameters
 	id := utils.ExtractIDFromImageURL(imageSrc)
 	baseImageURL := strings.Split(imageSrc, "?")[0]
@@ -212,24 +258,72 @@ func (hs *HTMLService) downloadImage(imageSrc, path string, stats *models.Downlo
 	filename := utils.SanitizeFilename(id + fileExt)
 
 	fileType := utils.ClassifyFileType(fileExt)
+	post.FileURL = imageSrc
+	post.MD5 = utils.ExtractMD5FromURL(baseImageURL)
 	
-	if fileType == "gif" && config.AppSettings.Gif {
+	if fileType == "gif" && hs.options.Gif {
 		filePath := filepath.Join(path, "Gif", filename)
-		err := hs.downloadService.Download(imageSrc, filePath)
+		onBytes := hs.options.byteProgress(post, imageSrc, filePath)
+		_, err := hs.output.save(hs.downloadService, post, imageSrc, filePath, onBytes)
 		if err == nil {
 			stats.Gifs++
 		}
-		return err
-	} else if fileType == "image" && config.AppSettings.Images {
+		return filePath, "", err
+	} else if fileType == "image" && hs.options.Images {
+		if hs.output.isSkipped(filename) {
+			return "", "", fmt.Errorf("near duplicate") // Removed earlier as a near-duplicate
+		}
+
 		filePath := filepath.Join(path, "Images", filename)
-		err := hs.downloadService.Download(imageSrc, filePath)
-		if err == nil {
-			stats.Images++
+		onBytes := hs.options.byteProgress(post, imageSrc, filePath)
+		result, err := hs.output.save(hs.downloadService, post, imageSrc, filePath, onBytes)
+		if err != nil {
+			return filePath, "", err
+		}
+		if result.match != "" {
+			stats.NearDuplicates++
+		}
+		if result.skipped {
+			return filePath, "", fmt.Errorf("near duplicate")
 		}
-		return err
+
+		stats.Images++
+		return filePath, result.warning, nil
 	}
 
-	return nil // File type disabled in settings
+	return "", "", nil // File type disabled in settings
+}
+
+// parsePostPage reads the post metadata shown in the sidebar of a post page
+func (hs *HTMLService) parsePostPage(doc *goquery.Document, postURL string) models.Post {
+	post := models.Post{ID: utils.ExtractPostIDFromURL(postURL)}
+
+	var tags []string
+	doc.Find("#tag-sidebar li a").Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if !exists || !strings.Contains(href, "tags=") {
+			return
+		}
+		if tag := utils.ExtractTagsFromURL(href); tag != "" {
+			tags = append(tags, strings.ReplaceAll(tag, " ", "_"))
+		}
+	})
+	post.Tags = strings.Join(tags, " ")
+
+	doc.Find("#stats li").Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(text, "Rating:"):
+			post.Rating = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(text, "Rating:")))
+		case strings.HasPrefix(text, "Score:"):
+			fields := strings.Fields(strings.TrimPrefix(text, "Score:"))
+			if len(fields) > 0 {
+				post.Score, _ = strconv.Atoi(fields[0])
+			}
+		}
+	})
+
+	return post
 }
 
 func (hs *HTMLService) extractFilename(url string) string {
@@ -237,7 +331,7 @@ func (hs *HTMLService) extractFilename(url string) string {
 }
 
 func (hs *HTMLService) loadHTMLDocument(url string) (*goquery.Document, error) {
-	resp, err := hs.client.Get(url)
+	resp, err := hs.client.getPage(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch URL %s: %w", url, err)
 	}
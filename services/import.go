@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"r34-go/models"
+)
+
+// Import statuses
+const (
+	ImportIndexed   = "indexed"
+	ImportMatched   = "matched"
+	ImportUnmatched = "unmatched"
+	ImportConflict  = "conflict"
+	ImportFailed    = "failed"
+)
+
+var (
+	// md5Name matches file names made of an MD5, as saved by many tools
+	md5Name = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	// idName matches file names made of a post ID
+	idName = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// ImportResult is the outcome of importing one file
+type ImportResult struct {
+	// Source is the file as found, Path where it is indexed
+	Source string
+	Path   string
+	Status string
+	// MatchedBy tells how the post was found: md5, filename md5 or id
+	MatchedBy string
+	Post      *models.Post
+	// Reason explains a failure, or a problem with a matched file such as
+	// a metadata sidecar left behind
+	Reason string
+}
+
+// Importer reconciles files saved by other tools with the library of an
+// output directory. Each file is looked up by its MD5, or by the MD5 or post
+// ID in its name, then indexed and optionally moved into the layout of
+// downloads so later runs skip it.
+type Importer struct {
+	api    *APIService
+	output *outputState
+	dir    string
+	move   bool
+	dryRun bool
+}
+
+// NewImporter creates an importer indexing into the output directory dir.
+// With move set files are moved to the path a download would give them.
+func NewImporter(api *APIService, dir string, options DownloadOptions, move, dryRun bool) (*Importer, error) {
+	// Every file type is imported, whatever the download settings
+	options.Images, options.Gif, options.Video = true, true, true
+	api.SetOptions(options)
+
+	library, err := OpenLibrary(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the library of %s: %w", dir, err)
+	}
+
+	return &Importer{
+		api:    api,
+		output: &outputState{options: options, library: library},
+		dir:    dir,
+		move:   move,
+		dryRun: dryRun,
+	}, nil
+}
+
+// Import looks up a file and indexes it
+func (im *Importer) Import(source string) ImportResult {
+	result := ImportResult{Source: source, Path: source}
+
+	relPath, err := im.output.library.relative(source)
+	inside := err == nil && !strings.HasPrefix(relPath, "../")
+	if inside && im.output.library.Has(relPath) {
+		result.Status = ImportIndexed
+		return result
+	}
+	if !inside && !im.move {
+		result.Status = ImportFailed
+		result.Reason = "outside the output directory, use --move"
+		return result
+	}
+
+	hash, err := FileMD5(source)
+	if err != nil {
+		result.Status = ImportFailed
+		result.Reason = err.Error()
+		return result
+	}
+
+	post, matchedBy, err := im.lookup(source, hash)
+	if err != nil {
+		result.Status = ImportFailed
+		result.Reason = err.Error()
+		return result
+	}
+	if post == nil {
+		result.Status = ImportUnmatched
+		return result
+	}
+	posts := []models.Post{*post}
+	im.api.categorize(posts)
+	result.Post = &posts[0]
+	result.MatchedBy = matchedBy
+
+	if im.move {
+		result.Path = im.targetPath(source, *result.Post, hash)
+		if result.Path != source {
+			if _, err := os.Lstat(result.Path); err == nil {
+				result.Status = ImportConflict
+				result.Reason = "target already exists"
+				return result
+			}
+		}
+	}
+
+	result.Status = ImportMatched
+	if im.dryRun {
+		return result
+	}
+
+	if result.Path != source {
+		if err := moveFile(source, result.Path); err != nil {
+			result.Status = ImportFailed
+			result.Reason = fmt.Sprintf("failed to move: %v", err)
+			return result
+		}
+		// Bring an existing metadata sidecar along
+		if _, err := os.Stat(source + ".json"); err == nil {
+			if err := moveFile(source+".json", result.Path+".json"); err != nil {
+				result.Reason = fmt.Sprintf("failed to move the metadata sidecar: %v", err)
+			}
+		}
+	}
+
+	im.output.record(*result.Post, result.Path)
+	return result
+}
+
+// Close updates the views with the imported posts when enabled
+func (im *Importer) Close() error {
+	if im.dryRun {
+		return nil
+	}
+	return im.output.close(nil)
+}
+
+// lookup finds the post of a file by its MD5, then by the MD5 or post ID
+// in its name. Files converted or resized by other tools only match by name.
+func (im *Importer) lookup(source, hash string) (*models.Post, string, error) {
+	post, err := im.findByMD5(hash)
+	if post != nil || err != nil {
+		return post, "md5", err
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(source), filepath.Ext(source)))
+	switch {
+	case md5Name.MatchString(name) && name != hash:
+		post, err := im.findByMD5(name)
+		return post, "filename md5", err
+	case idName.MatchString(name):
+		post, err := im.api.GetPost(name)
+		if err != nil && strings.HasSuffix(err.Error(), "not found") {
+			return nil, "", nil
+		}
+		return post, "id", err
+	}
+	return nil, "", nil
+}
+
+func (im *Importer) findByMD5(hash string) (*models.Post, error) {
+	posts, err := im.api.GetPosts("md5:"+hash, 0, 1)
+	if err != nil || len(posts) == 0 {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+// targetPath returns where a download would save the post. Files that
+// differ from the original keep their own extension.
+func (im *Importer) targetPath(source string, post models.Post, hash string) string {
+	file, _ := im.api.options.fileFor(post)
+	name := file.name
+	if hash != post.MD5 {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + strings.ToLower(filepath.Ext(source))
+	}
+	return filepath.Join(im.dir, file.folder, name)
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"r34-go/config"
+	"r34-go/events"
+	"r34-go/metrics"
+	"r34-go/models"
+	"r34-go/query"
+)
+
+// JobRunner executes download jobs over a shared client and rate limiter
+type JobRunner struct {
+	client  *Client
+	metrics *metrics.Registry
+
+	notifier    *Notifier
+	onHookError func(err error)
+}
+
+// NewJobRunner creates a new job runner sharing the given client
+func NewJobRunner(client *Client) *JobRunner {
+	return &JobRunner{client: client}
+}
+
+// SetMetrics records the requests and results of every job in reg,
+// labeled by source and job name
+func (jr *JobRunner) SetMetrics(reg *metrics.Registry) {
+	jr.metrics = reg
+}
+
+// SetNotifier reports every finished job to the hooks of notifier.
+// Jobs stopped by a canceled context are not reported. Hook failures are
+// passed to onHookError.
+func (jr *JobRunner) SetNotifier(notifier *Notifier, onHookError func(err error)) {
+	jr.notifier = notifier
+	jr.onHookError = onHookError
+}
+
+// Run executes a single job and returns its download statistics
+func (jr *JobRunner) Run(job models.Job, progressCallback models.ProgressCallback) (*models.DownloadStats, error) {
+	return jr.run(context.Background(), job, progressCallback, nil, nil)
+}
+
+// RunContext executes a job like Run, stopping between posts with the
+// context error once ctx is canceled. onStats receives the statistics after
+// every post and sink, when set, the progress events of the job.
+func (jr *JobRunner) RunContext(ctx context.Context, job models.Job, onStats models.StatsCallback, sink models.EventSink) (*models.DownloadStats, error) {
+	return jr.run(ctx, job, nil, onStats, sink)
+}
+
+func (jr *JobRunner) run(ctx context.Context, job models.Job, progressCallback models.ProgressCallback, onStats models.StatsCallback, sink models.EventSink) (*models.DownloadStats, error) {
+	if job.Output == "" {
+		job.Output = config.AppSettings.Output
+	}
+	if jr.notifier == nil {
+		return jr.download(ctx, job, progressCallback, onStats, sink)
+	}
+
+	files := &events.FileList{}
+	if sink != nil {
+		sink = events.Multi{sink, files}
+	} else {
+		sink = files
+	}
+
+	started := time.Now()
+	stats, err := jr.download(ctx, job, progressCallback, onStats, sink)
+	if ctx.Err() == nil {
+		report := NewJobReport(job, stats, err, files.Files(), started)
+		if hookErr := jr.notifier.Notify(report); hookErr != nil && jr.onHookError != nil {
+			jr.onHookError(hookErr)
+		}
+	}
+	return stats, err
+}
+
+// download runs the searches and downloads of a job
+func (jr *JobRunner) download(ctx context.Context, job models.Job, progressCallback models.ProgressCallback, onStats models.StatsCallback, sink models.EventSink) (*models.DownloadStats, error) {
+	if job.Tags == "" {
+		return nil, fmt.Errorf("job has no tags")
+	}
+
+	plan, err := query.Compile(job.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag query: %w", err)
+	}
+
+	options := jobOptions(job)
+	if err := ValidateFilenameTemplate(options.FilenameTemplate); err != nil {
+		return nil, err
+	}
+	options.Query = plan.Filter
+	options.Context = ctx
+	options.OnStats = onStats
+	options.Events = sink
+	if !options.Images && !options.Gif && !options.Video {
+		return nil, fmt.Errorf("at least one file type must be enabled")
+	}
+
+	output := job.Output
+	if output == "" {
+		output = config.AppSettings.Output
+	}
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	quantity := job.Quantity
+	if quantity == 0 {
+		quantity = config.AppSettings.Limit
+	}
+
+	source := job.Source
+	if source == "" {
+		source = "api"
+	}
+	client := jr.client
+	if jr.metrics != nil {
+		client = client.Instrument(jr.metrics, metrics.Labels{Source: source, Job: job.DisplayName()})
+	}
+
+	switch source {
+	case "api":
+		apiService := NewAPIServiceWithClient(client)
+		apiService.SetOptions(options)
+
+		count, err := apiService.GetContentCount(plan.Tags)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return &models.DownloadStats{}, nil
+		}
+		if int(quantity) > count {
+			quantity = uint16(count)
+		}
+
+		return apiService.DownloadContent(output, plan.Tags, quantity, progressCallback)
+	case "html":
+		htmlService := NewHTMLServiceWithClient(client)
+		htmlService.SetOptions(options)
+
+		found, err := htmlService.IsSomethingFound(plan.Tags)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return &models.DownloadStats{}, nil
+		}
+
+		return htmlService.DownloadContent(output, plan.Tags, quantity, progressCallback)
+	default:
+		return nil, fmt.Errorf("unknown source %q (expected api or html)", job.Source)
+	}
+}
+
+// RunAll executes jobs with at most parallel jobs running at once.
+// onDone is called after each job finishes; results keep the input order.
+func (jr *JobRunner) RunAll(jobs []models.Job, parallel int, onDone func(models.JobResult)) []models.JobResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]models.JobResult, len(jobs))
+	sem := make(chan struct{}, parallel)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, job models.Job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			stats, err := jr.Run(job, nil)
+			result := models.JobResult{
+				Job:      job,
+				Stats:    stats,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+			results[i] = result
+
+			if onDone != nil {
+				mu.Lock()
+				onDone(result)
+				mu.Unlock()
+			}
+		}(i, job)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// jobOptions merges a job's file type switches with the application settings
+func jobOptions(job models.Job) DownloadOptions {
+	options := OptionsFromSettings()
+	if job.Images != nil {
+		options.Images = *job.Images
+	}
+	if job.Gifs != nil {
+		options.Gif = *job.Gifs
+	}
+	if job.Videos != nil {
+		options.Video = *job.Videos
+	}
+	options.Filter = job.Filters
+	return options
+}
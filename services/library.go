@@ -0,0 +1,288 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"r34-go/config"
+	"r34-go/models"
+)
+
+const (
+	libraryDir = "library"
+	// legacyLibraryFile is the index older versions kept inside the output
+	// directory
+	legacyLibraryFile = ".library.jsonl"
+)
+
+// Library is the index of posts downloaded into an output directory, stored
+// as one JSON entry per line so new downloads are simply appended
+type Library struct {
+	mu      sync.Mutex
+	dir     string
+	path    string
+	entries map[string]models.LibraryEntry
+	added   []models.LibraryEntry
+	// byID and byMD5 map posts to their latest entry, archived or not
+	byID  map[string]models.LibraryEntry
+	byMD5 map[string]models.LibraryEntry
+}
+
+// LibraryPath returns the file holding the library index of an output
+// directory. Indexes live in the data directory, named after the output
+// directory and a hash of its absolute path.
+func LibraryPath(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	name := fmt.Sprintf("%s-%s.jsonl", filepath.Base(absDir), hex.EncodeToString(sum[:6]))
+	return filepath.Join(config.DataDir(), libraryDir, name), nil
+}
+
+// OpenLibrary loads the library index of an output directory, moving an
+// index left inside the directory by older versions to the data directory
+func OpenLibrary(dir string) (*Library, error) {
+	path, err := LibraryPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	library := &Library{
+		dir:     dir,
+		path:    path,
+		entries: make(map[string]models.LibraryEntry),
+		byID:    make(map[string]models.LibraryEntry),
+		byMD5:   make(map[string]models.LibraryEntry),
+	}
+
+	if err := migrateLibrary(filepath.Join(dir, legacyLibraryFile), path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return library, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry models.LibraryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Skip damaged lines rather than losing the whole index
+		}
+		// Later lines replace earlier ones for the same file
+		library.index(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return library, nil
+}
+
+// migrateLibrary moves a legacy index to path. When both exist the legacy
+// lines are appended, as they are older than anything written since.
+func migrateLibrary(legacy, path string) error {
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := moveFile(legacy, path); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", legacy, path, err)
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(legacy)
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Legacy lines go first so the newer entries still win
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, current...), 0644); err != nil {
+		return fmt.Errorf("failed to merge %s into %s: %w", legacy, path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return os.Remove(legacy)
+}
+
+// Dir returns the output directory of the library
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// Has checks if a file, relative to the output directory, is indexed
+func (l *Library) Has(relPath string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[filepath.ToSlash(relPath)]
+	return ok
+}
+
+// Entry returns the index entry of a file relative to the output directory
+func (l *Library) Entry(relPath string) (models.LibraryEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[filepath.ToSlash(relPath)]
+	return entry, ok
+}
+
+// Find returns the entry of the post, or of another post with the same MD5,
+// whose file or archive still exists
+func (l *Library) Find(post models.Post) (models.LibraryEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range []models.LibraryEntry{l.byID[post.ID], l.byMD5[post.MD5]} {
+		location := entry.Path
+		if entry.Archive != "" {
+			location = entry.Archive
+		}
+		if location == "" {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(l.dir, filepath.FromSlash(location))); err == nil {
+			return entry, true
+		}
+	}
+	return models.LibraryEntry{}, false
+}
+
+// Add indexes a post saved at filePath, which may be absolute or relative to
+// the output directory
+func (l *Library) Add(post models.Post, filePath string) error {
+	relPath, err := l.relative(filePath)
+	if err != nil {
+		return err
+	}
+
+	return l.append(models.LibraryEntry{
+		Post:         post,
+		Path:         relPath,
+		DownloadedAt: time.Now(),
+	})
+}
+
+// AddArchived indexes a post only saved into an archive, as name inside the
+// archive at archivePath. Such entries are found by Find but aren't files
+// of the output directory.
+func (l *Library) AddArchived(post models.Post, name, archivePath string) error {
+	relArchive, err := l.relative(archivePath)
+	if err != nil {
+		return err
+	}
+
+	return l.append(models.LibraryEntry{
+		Post:         post,
+		Path:         name,
+		DownloadedAt: time.Now(),
+		Archive:      relArchive,
+	})
+}
+
+func (l *Library) append(entry models.LibraryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	l.index(entry)
+	if entry.Archive == "" {
+		l.added = append(l.added, entry)
+	}
+	return nil
+}
+
+// Entries returns every indexed entry whose file still exists, sorted by path
+func (l *Library) Entries() []models.LibraryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]models.LibraryEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if _, err := os.Lstat(filepath.Join(l.dir, filepath.FromSlash(entry.Path))); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// Added returns the entries added since the library was opened
+func (l *Library) Added() []models.LibraryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]models.LibraryEntry(nil), l.added...)
+}
+
+// index adds an entry to the lookups, archived ones are left out of the
+// files of the output directory
+func (l *Library) index(entry models.LibraryEntry) {
+	if entry.Archive == "" {
+		l.entries[entry.Path] = entry
+	}
+	if entry.Post.ID != "" {
+		l.byID[entry.Post.ID] = entry
+	}
+	if entry.Post.MD5 != "" {
+		l.byMD5[entry.Post.MD5] = entry
+	}
+}
+
+func (l *Library) relative(filePath string) (string, error) {
+	absDir, err := filepath.Abs(l.dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relPath), nil
+}
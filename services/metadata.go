@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+// DefaultFilenameTemplate names files after the post ID
+const DefaultFilenameTemplate = "{id}"
+
+// maxFilenameLength is the length utils.SanitizeFilename cuts names to
+const maxFilenameLength = 200
+
+// categoryPlaceholders need resolved tag categories to be filled in
+var categoryPlaceholders = []string{"{artist}", "{character}", "{copyright}"}
+
+// uniquePlaceholders tell posts apart, a template needs one of them so no
+// two posts get the same file name
+var uniquePlaceholders = []string{"{id}", "{md5}"}
+
+// ValidateFilenameTemplate checks that a template gives every post its own
+// file name. An empty template uses the default.
+func ValidateFilenameTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	for _, placeholder := range uniquePlaceholders {
+		if strings.Contains(template, placeholder) {
+			return nil
+		}
+	}
+	return fmt.Errorf("filename template %q must contain {id} or {md5} so posts get their own file names", template)
+}
+
+// FormatFilename builds a file name (without extension) for a post from a
+// template such as "{artist}_{id}". Supported placeholders are {id}, {md5},
+// {rating}, {score}, {width}, {height}, {artist}, {character} and {copyright}.
+func FormatFilename(template string, post models.Post) string {
+	if template == "" {
+		template = DefaultFilenameTemplate
+	}
+
+	var groups models.TagGroups
+	if post.Groups != nil {
+		groups = *post.Groups
+	}
+
+	values := []string{
+		"{id}", post.ID,
+		"{md5}", post.MD5,
+		"{rating}", RatingName(post.Rating),
+		"{score}", strconv.Itoa(post.Score),
+		"{width}", strconv.Itoa(post.Width),
+		"{height}", strconv.Itoa(post.Height),
+	}
+	tagGroups := map[string]string{
+		"{artist}":    joinTagGroup(groups.Artist),
+		"{character}": joinTagGroup(groups.Character),
+		"{copyright}": joinTagGroup(groups.Copyright),
+	}
+
+	// SanitizeFilename cuts long names from the end, which could drop the
+	// ID, so the tag groups are shortened to fit first
+	fixed := strings.NewReplacer(append(values,
+		"{artist}", "", "{character}", "", "{copyright}", "")...).Replace(template)
+	fitTagGroups(template, tagGroups, maxFilenameLength-len(fixed))
+
+	for _, placeholder := range categoryPlaceholders {
+		values = append(values, placeholder, tagGroups[placeholder])
+	}
+	return utils.SanitizeFilename(strings.NewReplacer(values...).Replace(template))
+}
+
+// fitTagGroups shortens the tag group values so that every use of them in
+// template takes at most budget bytes together. Short groups are kept
+// whole and the rest share what is left.
+func fitTagGroups(template string, tagGroups map[string]string, budget int) {
+	budget = max(budget, 0)
+	uses := make(map[string]int)
+	var long []string
+	for _, placeholder := range categoryPlaceholders {
+		if n := strings.Count(template, placeholder); n > 0 {
+			uses[placeholder] = n
+			long = append(long, placeholder)
+		}
+	}
+
+	for len(long) > 0 {
+		count := 0
+		for _, placeholder := range long {
+			count += uses[placeholder]
+		}
+		share := budget / count
+
+		var longer []string
+		for _, placeholder := range long {
+			if len(tagGroups[placeholder]) > share {
+				longer = append(longer, placeholder)
+			} else {
+				budget -= len(tagGroups[placeholder]) * uses[placeholder]
+			}
+		}
+		if len(longer) == len(long) {
+			for _, placeholder := range longer {
+				tagGroups[placeholder] = truncateTagGroup(tagGroups[placeholder], share)
+			}
+			return
+		}
+		long = longer
+	}
+}
+
+// truncateTagGroup cuts a joined tag group to at most n bytes without
+// splitting a character or leaving a dangling separator
+func truncateTagGroup(value string, n int) string {
+	if len(value) <= n {
+		return value
+	}
+	for n > 0 && !utf8.RuneStart(value[n]) {
+		n--
+	}
+	return strings.TrimRight(value[:n], "+_")
+}
+
+// TemplateNeedsCategories checks if a filename template uses tag categories
+func TemplateNeedsCategories(template string) bool {
+	for _, placeholder := range categoryPlaceholders {
+		if strings.Contains(template, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSidecar saves the post metadata as JSON next to the downloaded file
+func WriteSidecar(filePath string, post models.Post) error {
+	data, err := json.MarshalIndent(post, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath+".json", data, 0644)
+}
+
+// joinTagGroup joins the first few tags of a category for use in file names
+func joinTagGroup(tags []string) string {
+	if len(tags) == 0 {
+		return "unknown"
+	}
+	if len(tags) > 3 {
+		tags = tags[:3]
+	}
+	return strings.Join(tags, "+")
+}
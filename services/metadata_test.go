@@ -0,0 +1,70 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"r34-go/models"
+)
+
+func TestFormatFilename(t *testing.T) {
+	post := models.Post{
+		ID: "123", MD5: "abc", Rating: "e", Score: 42,
+		Groups: &models.TagGroups{
+			Artist:    []string{"artist_a", "artist_b"},
+			Character: []string{"hu_tao_(genshin_impact)"},
+		},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "123"},
+		{"{artist}_{id}", "artist_a+artist_b_123"},
+		{"{copyright}/{character}-{md5}", "unknown_hu_tao_(genshin_impact)-abc"},
+		{"{rating}_{score}_{id}", "explicit_42_123"},
+	}
+	for _, tt := range tests {
+		if got := FormatFilename(tt.template, post); got != tt.want {
+			t.Errorf("FormatFilename(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFilenameLongGroups(t *testing.T) {
+	long := []string{strings.Repeat("a", 90), strings.Repeat("b", 90), strings.Repeat("ü", 45)}
+	post := models.Post{
+		ID:  "123456",
+		MD5: "0123456789abcdef0123456789abcdef",
+		Groups: &models.TagGroups{
+			Artist:    []string{"short"},
+			Character: long,
+			Copyright: long,
+		},
+	}
+
+	tests := []string{
+		"{character}_{id}",
+		"{artist}_{character}_{copyright}_{md5}",
+		"{character}/{character}_{id}_{copyright}",
+	}
+	for _, template := range tests {
+		got := FormatFilename(template, post)
+		if len(got) > maxFilenameLength {
+			t.Errorf("FormatFilename(%q) is %d bytes, want at most %d", template, len(got), maxFilenameLength)
+		}
+		if !strings.Contains(got, post.ID) && !strings.Contains(got, post.MD5) {
+			t.Errorf("FormatFilename(%q) = %q lost the ID and MD5", template, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("FormatFilename(%q) = %q split a character", template, got)
+		}
+	}
+
+	// Groups that fit are kept whole
+	if got := FormatFilename("{artist}_{character}_{id}", post); !strings.HasPrefix(got, "short_") {
+		t.Errorf("FormatFilename = %q, want the short artist kept", got)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/query"
+	"r34-go/utils"
+)
+
+// DownloadOptions holds the per-run switches used when saving posts
+type DownloadOptions struct {
+	Images bool
+	Gif    bool
+	Video  bool
+	Filter models.Filter
+	Store  *ContentStore
+
+	// Query is the part of a tag query the site can't search for, checked
+	// against each post. Nil when the site search is exact.
+	Query query.Node
+
+	// Near-duplicate detection for saved images
+	PHash         bool
+	PHashDistance int
+	PHashSkip     bool
+
+	Views config.ViewSettings
+
+	FilenameTemplate string
+	Sidecar          bool
+	TagCategories    bool
+
+	// Archive bundles the saved files into archives when its format is set
+	Archive ArchiveOptions
+
+	// Context stops a download between posts when canceled
+	Context context.Context
+	// OnStats receives the statistics after every post
+	OnStats models.StatsCallback
+	// Events receives progress events, when set
+	Events models.EventSink
+}
+
+// OptionsFromSettings builds download options from the application settings
+func OptionsFromSettings() DownloadOptions {
+	options := DownloadOptions{
+		Images:        config.AppSettings.Images,
+		Gif:           config.AppSettings.Gif,
+		Video:         config.AppSettings.Video,
+		PHash:         config.AppSettings.PHash,
+		PHashDistance: config.AppSettings.PHashDistance,
+		PHashSkip:     config.AppSettings.PHashAction == "skip",
+		Views:         config.AppSettings.Views,
+
+		FilenameTemplate: config.AppSettings.FilenameTemplate,
+		Sidecar:          config.AppSettings.Sidecar,
+		TagCategories:    config.AppSettings.TagCategories,
+	}
+	options.Archive = ArchiveOptions{
+		Format:    config.AppSettings.Archive.Format,
+		KeepFiles: config.AppSettings.Archive.KeepFiles,
+	}
+	// An empty split, or an invalid one reported by config validation,
+	// writes a single archive
+	options.Archive.SplitSize, _ = utils.ParseFileSize(config.AppSettings.Archive.Split)
+	if config.AppSettings.StoreDir != "" {
+		options.Store = NewContentStore(config.AppSettings.StoreDir, config.AppSettings.LinkMode)
+	}
+	return options
+}
+
+// postFile is where the file of a post is downloaded from and saved to
+type postFile struct {
+	url string
+	// folder is Images, Gif or Video
+	folder string
+	// name follows the filename template
+	name string
+}
+
+// fileFor picks the download URL, folder and file name of a post. When the
+// file type is disabled it returns the reason instead.
+func (o DownloadOptions) fileFor(post models.Post) (postFile, string) {
+	ext := strings.ToLower(filepath.Ext(post.FileURL))
+	file := postFile{url: post.FileURL, name: FormatFilename(o.FilenameTemplate, post) + ext}
+
+	switch ext {
+	case ".mp4", ".webm":
+		if !o.Video {
+			return file, "videos disabled"
+		}
+		// Use sample URL if available for videos
+		if post.SampleURL != "" {
+			file.url = post.SampleURL
+		}
+		file.folder = "Video"
+	case ".gif":
+		if !o.Gif {
+			return file, "gifs disabled"
+		}
+		file.folder = "Gif"
+	default:
+		if !o.Images {
+			return file, "images disabled"
+		}
+		file.folder = "Images"
+	}
+	return file, ""
+}
+
+// needsCategories checks if any option relies on resolved tag categories
+func (o DownloadOptions) needsCategories() bool {
+	return o.TagCategories || o.Sidecar || o.Filter.UsesCategories() || TemplateNeedsCategories(o.FilenameTemplate)
+}
+
+// matches checks a post against the filters and the local part of the query
+func (o DownloadOptions) matches(post models.Post) bool {
+	if !o.Filter.Match(post) {
+		return false
+	}
+	return o.Query == nil || o.Query.Eval(post)
+}
+
+// canceled returns the context error once the run has been canceled
+func (o DownloadOptions) canceled() error {
+	if o.Context == nil {
+		return nil
+	}
+	return o.Context.Err()
+}
+
+// reportStats passes a copy of the current statistics to OnStats
+func (o DownloadOptions) reportStats(stats *models.DownloadStats) {
+	if o.OnStats != nil {
+		o.OnStats(*stats)
+	}
+}
+
+// emit publishes an event to the event sink, if there is one
+func (o DownloadOptions) emit(event models.Event) {
+	if o.Events == nil {
+		return
+	}
+	event.Time = time.Now()
+	o.Events.Publish(event)
+}
+
+// byteProgress returns a callback reporting the bytes written for a file as
+// events, or nil without an event sink
+func (o DownloadOptions) byteProgress(post models.Post, url, filePath string) ByteCallback {
+	if o.Events == nil {
+		return nil
+	}
+
+	o.emit(models.Event{Type: models.EventFileStarted, PostID: post.ID, URL: url, File: filePath})
+	return func(written, size int64) {
+		o.emit(models.Event{Type: models.EventBytesWritten, PostID: post.ID, File: filePath, Bytes: written, Size: size})
+	}
+}
+
+// emitResult publishes the outcome of a post together with the progress of
+// the run
+func (o DownloadOptions) emitResult(post models.Post, result postResult, current, total int) {
+	event := models.Event{
+		PostID:  post.ID,
+		File:    result.file,
+		Reason:  result.reason,
+		Current: current,
+		Total:   total,
+	}
+	switch result.status {
+	case "downloaded":
+		event.Type = models.EventFileDone
+	case "failed":
+		event.Type = models.EventFileFailed
+	default:
+		event.Type = models.EventPostSkipped
+	}
+	o.emit(event)
+}
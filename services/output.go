@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"r34-go/models"
+)
+
+// outputState holds the indexes of an output directory that are kept up to
+// date while a download run saves files into it
+type outputState struct {
+	options DownloadOptions
+	phash   *PHashIndex
+	library *Library
+
+	// archive bundles the saved files of the run when enabled, named
+	// relative to dir. Without KeepFiles downloads are streamed into it.
+	dir     string
+	archive *ArchiveWriter
+	// archiveErr is the first archive error, returned by close
+	archiveErr error
+}
+
+// openOutput loads the indexes of an output directory for a download run.
+// Index errors never stop a download, the affected index is just disabled.
+func openOutput(options DownloadOptions, dir string) *outputState {
+	output := &outputState{
+		options: options,
+		phash:   openPHashIndex(options, dir),
+		dir:     dir,
+	}
+
+	if library, err := OpenLibrary(dir); err == nil {
+		output.library = library
+	}
+
+	// Files stay loose when the archive can't be set up, close reports why
+	if options.Archive.enabled() {
+		format := options.Archive.Format
+		archive, err := NewArchiveWriter(ArchiveBase(dir, format), format, options.Archive.SplitSize)
+		if err != nil {
+			output.archiveErr = err
+		} else {
+			output.archive = archive
+		}
+	}
+
+	return output
+}
+
+// saved tells what save did with a file
+type saved struct {
+	// match is the near-duplicate the file matched, if any
+	match string
+	// skipped is set when the file wasn't kept as a near-duplicate
+	skipped bool
+	// warning tells why a kept file went without the near-duplicate check
+	warning string
+}
+
+// save downloads the file of a post to filePath, through the content store
+// for original files, checks images for near-duplicates and records the
+// post. Near-duplicates aren't kept in skip mode. In archive-only mode the
+// file is streamed into the archive instead.
+func (o *outputState) save(ds *DownloadService, post models.Post, url, filePath string, onBytes ByteCallback) (saved, error) {
+	if o.archive != nil && !o.options.Archive.KeepFiles {
+		return o.stream(ds, post, url, filePath, onBytes)
+	}
+
+	if err := ds.DownloadToStoreWithProgress(url, filePath, storeKey(post, url), o.options.Store, onBytes); err != nil {
+		return saved{}, err
+	}
+
+	match, err := checkNearDuplicate(o.phash, filePath, o.options.PHashDistance, o.options.PHashSkip)
+	result := saved{match: match}
+	if err != nil {
+		result.warning = err.Error()
+	} else if match != "" && o.options.PHashSkip {
+		result.skipped = true
+		return result, nil
+	}
+
+	o.record(post, filePath)
+	if o.archive != nil {
+		name, err := filepath.Rel(o.dir, filePath)
+		if err == nil {
+			err = o.archive.Add(&post, filePath, filepath.ToSlash(name))
+		}
+		if err != nil && o.archiveErr == nil {
+			o.archiveErr = fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+	return result, nil
+}
+
+// stream writes the file a post would get at filePath straight into the
+// archive, from the content store when it holds the file. Images are read
+// into memory first when they are checked for near-duplicates.
+func (o *outputState) stream(ds *DownloadService, post models.Post, url, filePath string, onBytes ByteCallback) (saved, error) {
+	if _, err := os.Lstat(filePath); err == nil {
+		return saved{}, fmt.Errorf("file already exists")
+	}
+	name, err := filepath.Rel(o.dir, filePath)
+	if err != nil {
+		return saved{}, err
+	}
+
+	var r io.Reader
+	var size int64
+	key, ext := storeKey(post, url), filepath.Ext(filePath)
+	if store := o.options.Store; store != nil && key != "" && store.Has(key, ext) {
+		file, err := os.Open(store.Path(key, ext))
+		if err != nil {
+			return saved{}, err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return saved{}, err
+		}
+		r, size = file, info.Size()
+	} else {
+		resp, err := ds.fetch(url)
+		if err != nil {
+			return saved{}, err
+		}
+		defer resp.Body.Close()
+		r, size = resp.Body, resp.ContentLength
+
+		if key != "" {
+			r = &verifiedReader{r: r, hash: md5.New(), want: strings.ToLower(key), url: url}
+		}
+		if onBytes != nil {
+			progress := &progressWriter{size: size, onBytes: onBytes}
+			defer progress.finish()
+			r = io.TeeReader(r, progress)
+		}
+	}
+
+	var result saved
+	if o.phash != nil && hashableImage(ext) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return saved{}, fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		if hash, err := imageHash(bytes.NewReader(data)); err != nil {
+			result.warning = fmt.Sprintf("failed to decode image %s: %v", name, err)
+		} else {
+			result.match = o.phash.check(filepath.Base(filePath), hash, o.options.PHashDistance, o.options.PHashSkip)
+			if result.match != "" && o.options.PHashSkip {
+				result.skipped = true
+				return result, nil
+			}
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if err := o.archive.AddReader(&post, r, size, filepath.ToSlash(name)); err != nil {
+		return saved{}, fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// verifiedReader fails at the end of a download whose MD5 doesn't match
+type verifiedReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want string
+	url  string
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if sum := hex.EncodeToString(v.hash.Sum(nil)); sum != v.want {
+			return n, fmt.Errorf("md5 mismatch for %s: got %s, expected %s", v.url, sum, v.want)
+		}
+	}
+	return n, err
+}
+
+// record indexes a post saved at filePath in the library unless it is
+// already indexed, and writes its metadata sidecar when enabled
+func (o *outputState) record(post models.Post, filePath string) {
+	if o == nil {
+		return
+	}
+
+	if o.options.Sidecar {
+		if _, err := os.Stat(filePath + ".json"); os.IsNotExist(err) {
+			WriteSidecar(filePath, post)
+		}
+	}
+
+	if o.library == nil {
+		return
+	}
+
+	relPath, err := o.library.relative(filePath)
+	if err != nil || o.library.Has(relPath) {
+		return
+	}
+
+	o.library.Add(post, filePath)
+}
+
+// find returns the file, or the archive, of a post saved by an earlier run
+// or an import, possibly under another name
+func (o *outputState) find(post models.Post) (string, bool) {
+	if o == nil || o.library == nil {
+		return "", false
+	}
+	entry, ok := o.library.Find(post)
+	if !ok {
+		return "", false
+	}
+	location := entry.Path
+	if entry.Archive != "" {
+		location = entry.Archive
+	}
+	return filepath.Join(o.library.Dir(), filepath.FromSlash(location)), true
+}
+
+// isSkipped checks if an image was removed earlier as a near-duplicate
+func (o *outputState) isSkipped(filename string) bool {
+	return o != nil && o.phash != nil && o.phash.IsSkipped(filename)
+}
+
+// close saves the indexes, updates the views with the new downloads and
+// completes the archive, indexing the posts only saved into it. Archive
+// errors are returned, the paths of the archives are added to stats when
+// given.
+func (o *outputState) close(stats *models.DownloadStats) error {
+	if o == nil {
+		return nil
+	}
+
+	if o.phash != nil {
+		o.phash.Save()
+	}
+
+	if o.library != nil && o.options.Views.Auto {
+		NewViewBuilder(o.library, o.options.Views).Update(o.library.Added())
+	}
+
+	err := o.archiveErr
+	if o.archive != nil {
+		if closeErr := o.archive.Close(); err == nil {
+			err = closeErr
+		}
+		if stats != nil {
+			stats.Archives = o.archive.Paths()
+		}
+		if o.library != nil && !o.options.Archive.KeepFiles {
+			for _, archived := range o.archive.Archived() {
+				o.library.AddArchived(archived.Post, archived.Name, archived.Archive)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const phashIndexFile = ".phash.json"
+
+// ImageHash calculates a 64-bit difference hash (dHash) of an image file.
+// Visually similar images, such as re-encodes and resizes, get hashes with
+// a small Hamming distance.
+func ImageHash(filePath string) (uint64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hash, err := imageHash(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image %s: %w", filePath, err)
+	}
+	return hash, nil
+}
+
+// imageHash calculates the dHash of an encoded image
+func imageHash(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Shrink to 9x8 grayscale and compare each pixel with its right neighbour
+	const width, height = 9, 8
+	gray := shrinkGray(img, width, height)
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if gray[y*width+x] < gray[y*width+x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash, nil
+}
+
+// HammingDistance returns the number of differing bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// shrinkGray averages the luminance of an image over a width x height grid
+func shrinkGray(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	gray := make([]float64, width*height)
+
+	for gy := 0; gy < height; gy++ {
+		y0 := bounds.Min.Y + gy*srcH/height
+		y1 := bounds.Min.Y + (gy+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for gx := 0; gx < width; gx++ {
+			x0 := bounds.Min.X + gx*srcW/width
+			x1 := bounds.Min.X + (gx+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum float64
+			var count int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			gray[gy*width+gx] = sum / float64(count)
+		}
+	}
+
+	return gray
+}
+
+// PHashIndex keeps the perceptual hashes of the images saved in an output
+// directory's Images folder
+type PHashIndex struct {
+	mu     sync.Mutex
+	dir    string
+	hashes map[string]uint64
+	// skipped maps near-duplicates that were removed to the image they matched,
+	// so they aren't downloaded again on the next run
+	skipped map[string]string
+}
+
+// phashIndexData is the on-disk format of a PHashIndex
+type phashIndexData struct {
+	Hashes  map[string]string `json:"hashes"`
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// LoadPHashIndex loads the hash index of an output directory and hashes any
+// image in its Images folder that isn't indexed yet
+func LoadPHashIndex(dir string) (*PHashIndex, error) {
+	index := &PHashIndex{
+		dir:     dir,
+		hashes:  make(map[string]uint64),
+		skipped: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, phashIndexFile))
+	if err == nil {
+		var stored phashIndexData
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", phashIndexFile, err)
+		}
+		for name, hex := range stored.Hashes {
+			if hash, err := strconv.ParseUint(hex, 16, 64); err == nil {
+				index.hashes[name] = hash
+			}
+		}
+		for name, match := range stored.Skipped {
+			index.skipped[name] = match
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "Images"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		present[name] = true
+
+		if _, ok := index.hashes[name]; ok {
+			continue
+		}
+		if !hashableImage(filepath.Ext(name)) {
+			continue
+		}
+		if hash, err := ImageHash(filepath.Join(dir, "Images", name)); err == nil {
+			index.hashes[name] = hash
+		}
+	}
+
+	// Forget files that were deleted since the index was saved
+	for name := range index.hashes {
+		if !present[name] {
+			delete(index.hashes, name)
+		}
+	}
+
+	return index, nil
+}
+
+// Nearest returns the indexed image closest to hash and its distance,
+// or an empty name when the index is empty
+func (pi *PHashIndex) Nearest(hash uint64) (string, int) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	best, bestDistance := "", 65
+	for name, other := range pi.hashes {
+		if distance := HammingDistance(hash, other); distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+
+	return best, bestDistance
+}
+
+// Add records the hash of an image in the Images folder
+func (pi *PHashIndex) Add(name string, hash uint64) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	pi.hashes[name] = hash
+}
+
+// keep indexes an image that check marked as skipped, when it stays after
+// all
+func (pi *PHashIndex) keep(name string, hash uint64) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	delete(pi.skipped, name)
+	pi.hashes[name] = hash
+}
+
+// IsSkipped checks if an image was removed earlier as a near-duplicate
+func (pi *PHashIndex) IsSkipped(name string) bool {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	_, ok := pi.skipped[name]
+	return ok
+}
+
+// Save writes the index next to the Images folder
+func (pi *PHashIndex) Save() error {
+	pi.mu.Lock()
+	stored := phashIndexData{
+		Hashes:  make(map[string]string, len(pi.hashes)),
+		Skipped: make(map[string]string, len(pi.skipped)),
+	}
+	for name, hash := range pi.hashes {
+		stored.Hashes[name] = fmt.Sprintf("%016x", hash)
+	}
+	for name, match := range pi.skipped {
+		stored.Skipped[name] = match
+	}
+	pi.mu.Unlock()
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(pi.dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(pi.dir, phashIndexFile), data, 0644)
+}
+
+// Clusters groups indexed images whose hashes are within maxDistance of
+// each other. Only groups with more than one image are returned, largest first.
+func (pi *PHashIndex) Clusters(maxDistance int) [][]string {
+	pi.mu.Lock()
+	names := make([]string, 0, len(pi.hashes))
+	for name := range pi.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hashes := make([]uint64, len(names))
+	for i, name := range names {
+		hashes[i] = pi.hashes[name]
+	}
+	pi.mu.Unlock()
+
+	// Union-find over every pair of close hashes
+	parent := make([]int, len(names))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			if HammingDistance(hashes[i], hashes[j]) <= maxDistance {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	groups := make(map[int][]string)
+	for i, name := range names {
+		root := find(i)
+		groups[root] = append(groups[root], name)
+	}
+
+	var clusters [][]string
+	for _, group := range groups {
+		if len(group) > 1 {
+			clusters = append(clusters, group)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		return clusters[i][0] < clusters[j][0]
+	})
+
+	return clusters
+}
+
+// openPHashIndex loads the hash index of an output directory when near-duplicate
+// detection is enabled. Errors disable detection rather than the download.
+func openPHashIndex(options DownloadOptions, dir string) *PHashIndex {
+	if !options.PHash {
+		return nil
+	}
+
+	index, err := LoadPHashIndex(dir)
+	if err != nil {
+		return nil
+	}
+	return index
+}
+
+// hashableImage checks if images with an extension can be decoded for
+// hashing. WebP and BMP have no decoder in the standard library, so those
+// files are kept without a check.
+func hashableImage(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// checkNearDuplicate hashes a freshly saved image and compares it with the
+// index. It returns the name of the near-duplicate it matched, if any. When
+// skip is set, near-duplicates are removed instead of being indexed; one
+// that can't be removed is indexed after all and returned with the error.
+func checkNearDuplicate(index *PHashIndex, filePath string, maxDistance int, skip bool) (string, error) {
+	if index == nil || !hashableImage(filepath.Ext(filePath)) {
+		return "", nil
+	}
+
+	hash, err := ImageHash(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(filePath)
+	match := index.check(name, hash, maxDistance, skip)
+	if match != "" && skip {
+		if err := os.Remove(filePath); err != nil {
+			index.keep(name, hash)
+			return match, fmt.Errorf("failed to remove near duplicate of %s: %w", match, err)
+		}
+	}
+	return match, nil
+}
+
+// check compares the hash of a new image named name with the index and
+// returns the near-duplicate it matched, if any. When skip is set,
+// near-duplicates are marked as skipped instead of being indexed.
+func (pi *PHashIndex) check(name string, hash uint64, maxDistance int, skip bool) string {
+	match, distance := pi.Nearest(hash)
+	if match == "" || distance > maxDistance {
+		pi.Add(name, hash)
+		return ""
+	}
+
+	if skip {
+		pi.mu.Lock()
+		pi.skipped[name] = match
+		pi.mu.Unlock()
+	} else {
+		pi.Add(name, hash)
+	}
+	return match
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+// PoolService handles downloading pools in reading order
+type PoolService struct {
+	htmlService     *HTMLService
+	apiService      *APIService
+	downloadService *DownloadService
+	options         DownloadOptions
+}
+
+// NewPoolService creates a new pool service instance
+func NewPoolService() *PoolService {
+	return NewPoolServiceWithClient(NewClient())
+}
+
+// NewPoolServiceWithClient creates a new pool service instance sharing the given client
+func NewPoolServiceWithClient(client *Client) *PoolService {
+	return &PoolService{
+		htmlService:     NewHTMLServiceWithClient(client),
+		apiService:      NewAPIServiceWithClient(client),
+		downloadService: NewDownloadServiceWithClient(client),
+		options:         OptionsFromSettings(),
+	}
+}
+
+// GetPool scrapes the pool page for its name and ordered post list
+func (ps *PoolService) GetPool(id string) (*models.Pool, error) {
+	doc, err := ps.htmlService.loadHTMLDocument(poolRequest(id))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &models.Pool{ID: id}
+
+	title := strings.TrimSpace(doc.Find("div#pool-show h4").First().Text())
+	if title == "" {
+		title = strings.TrimSpace(doc.Find("div.content h4").First().Text())
+	}
+	pool.Name = strings.TrimSpace(strings.TrimPrefix(title, "Pool:"))
+	if pool.Name == "" {
+		pool.Name = "pool_" + id
+	}
+
+	doc.Find("span.thumb a").Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if !exists {
+			return
+		}
+		if postID := utils.ExtractPostIDFromURL(href); postID != "" {
+			pool.PostIDs = append(pool.PostIDs, postID)
+		}
+	})
+
+	if len(pool.PostIDs) == 0 {
+		return nil, fmt.Errorf("pool %s has no posts or does not exist", id)
+	}
+
+	return pool, nil
+}
+
+// DownloadPool downloads every post of a pool into <path>/pools/<pool name>/
+// with a sequence prefix, optionally bundling the result as a CBZ archive
+func (ps *PoolService) DownloadPool(pool *models.Pool, path string, cbz bool, progressCallback models.ProgressCallback) (*models.PoolStats, error) {
+	stats := &models.PoolStats{Name: pool.Name}
+	stats.Total = len(pool.PostIDs)
+	stats.Directory = filepath.Join(path, "pools", utils.SanitizeFilename(pool.Name))
+
+	if err := os.MkdirAll(stats.Directory, 0755); err != nil {
+		return stats, fmt.Errorf("failed to create pool directory %s: %w", stats.Directory, err)
+	}
+
+	for i, postID := range pool.PostIDs {
+		ps.downloadPoolPost(postID, i+1, stats)
+
+		if progressCallback != nil {
+			progressCallback(i+1, stats.Total)
+		}
+	}
+
+	if cbz {
+		if err := packCBZ(stats); err != nil {
+			return stats, fmt.Errorf("failed to create CBZ: %w", err)
+		}
+	}
+
+	return stats, nil
+}
+
+func (ps *PoolService) downloadPoolPost(postID string, index int, stats *models.PoolStats) {
+	post, err := ps.apiService.GetPost(postID)
+	if err != nil || post.FileURL == "" {
+		stats.Failed++
+		return
+	}
+
+	fileExt := utils.GetFileExtension(post.FileURL)
+	switch utils.ClassifyFileType(fileExt) {
+	case "video":
+		if !ps.options.Video {
+			return
+		}
+	case "gif":
+		if !ps.options.Gif {
+			return
+		}
+	default:
+		if !ps.options.Images {
+			return
+		}
+	}
+
+	filename := utils.SequencePrefix(index, stats.Total) + "_" + post.ID + fileExt
+	filePath := filepath.Join(stats.Directory, filename)
+
+	if err := ps.downloadService.DownloadToStore(post.FileURL, filePath, storeKey(*post, post.FileURL), ps.options.Store); err != nil {
+		if err.Error() == "file already exists" {
+			stats.Skipped++
+			return
+		}
+		stats.Failed++
+		return
+	}
+
+	stats.Downloaded++
+	switch utils.ClassifyFileType(fileExt) {
+	case "video":
+		stats.Videos++
+	case "gif":
+		stats.Gifs++
+	default:
+		stats.Images++
+	}
+}
+
+// packCBZ bundles the pages of a pool directory into <directory>.cbz, in
+// name order which is reading order thanks to the sequence prefixes. Pages
+// linked from the content store are followed.
+func packCBZ(stats *models.PoolStats) error {
+	entries, err := os.ReadDir(stats.Directory)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		filePath := filepath.Join(stats.Directory, entry.Name())
+		if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() && utils.IsValidFileExtension(filepath.Ext(entry.Name())) {
+			files = append(files, filePath)
+		}
+	}
+
+	archive, err := NewArchiveWriter(stats.Directory, "cbz", 0)
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Pack(stats.Directory, files, nil); err != nil {
+		archive.Close()
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	if paths := archive.Paths(); len(paths) > 0 {
+		stats.Archive = paths[0]
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"r34-go/config"
+	"r34-go/events"
+	"r34-go/models"
+	"r34-go/query"
+)
+
+const (
+	queueFile = "queue.json"
+	// queueHistoryLimit is the number of finished jobs kept in the queue
+	queueHistoryLimit = 500
+)
+
+// Errors of Cancel
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// JobQueue is a persistent queue of download jobs run by a JobRunner.
+// Jobs that were running when the process stopped are queued again when the
+// queue is opened; files they already saved are skipped on the next run.
+type JobQueue struct {
+	path   string
+	runner *JobRunner
+	events models.EventSink
+
+	mu       sync.Mutex
+	jobs     []*models.QueuedJob
+	cancels  map[string]context.CancelFunc
+	canceled map[string]bool
+	// wake is closed and replaced whenever a job is submitted
+	wake chan struct{}
+}
+
+// QueuePath returns the default location of the job queue file
+func QueuePath() string {
+	return filepath.Join(config.DataDir(), queueFile)
+}
+
+// OpenJobQueue loads the job queue stored at path
+func OpenJobQueue(path string, runner *JobRunner) (*JobQueue, error) {
+	q := &JobQueue{
+		path:     path,
+		runner:   runner,
+		cancels:  make(map[string]context.CancelFunc),
+		canceled: make(map[string]bool),
+		wake:     make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read job queue: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &q.jobs); err != nil {
+			return nil, fmt.Errorf("failed to parse job queue %s: %w", path, err)
+		}
+	}
+
+	for _, job := range q.jobs {
+		if job.Status == models.JobRunning {
+			job.Status = models.JobQueued
+			job.StartedAt = nil
+		}
+	}
+
+	return q, nil
+}
+
+// SetEvents publishes the progress events of every job to sink, tagged
+// with the job ID
+func (q *JobQueue) SetEvents(sink models.EventSink) {
+	q.events = sink
+}
+
+// Submit validates a job and adds it to the end of the queue
+func (q *JobQueue) Submit(job models.Job) (models.QueuedJob, error) {
+	if strings.TrimSpace(job.Tags) == "" {
+		return models.QueuedJob{}, fmt.Errorf("job has no tags")
+	}
+	if _, err := query.Compile(job.Tags); err != nil {
+		return models.QueuedJob{}, fmt.Errorf("invalid tag query: %w", err)
+	}
+	if job.Source != "" && job.Source != "api" && job.Source != "html" {
+		return models.QueuedJob{}, fmt.Errorf("unknown source %q (expected api or html)", job.Source)
+	}
+
+	queued := &models.QueuedJob{
+		ID:        newJobID(),
+		Job:       job,
+		Status:    models.JobQueued,
+		CreatedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobs = append(q.jobs, queued)
+	if err := q.save(); err != nil {
+		q.jobs = q.jobs[:len(q.jobs)-1]
+		return models.QueuedJob{}, err
+	}
+
+	close(q.wake)
+	q.wake = make(chan struct{})
+	return *queued, nil
+}
+
+// Get returns a job by ID
+func (q *JobQueue) Get(id string) (models.QueuedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job := q.find(id); job != nil {
+		return *job, true
+	}
+	return models.QueuedJob{}, false
+}
+
+// List returns every job in submission order
+func (q *JobQueue) List() []models.QueuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]models.QueuedJob, len(q.jobs))
+	for i, job := range q.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+// Cancel removes a queued job from the queue or stops a running one.
+// A running job is marked canceled once it has stopped.
+func (q *JobQueue) Cancel(id string) (models.QueuedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return models.QueuedJob{}, ErrJobNotFound
+	}
+
+	switch job.Status {
+	case models.JobQueued:
+		now := time.Now()
+		job.Status = models.JobCanceled
+		job.FinishedAt = &now
+		if err := q.save(); err != nil {
+			return *job, err
+		}
+	case models.JobRunning:
+		q.canceled[id] = true
+		if cancel := q.cancels[id]; cancel != nil {
+			cancel()
+		}
+	default:
+		return *job, ErrJobFinished
+	}
+
+	return *job, nil
+}
+
+// Run executes queued jobs with the given number of workers until ctx is
+// canceled. Jobs interrupted by ctx are put back into the queue.
+func (q *JobQueue) Run(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, jobCtx, cancel := q.next(ctx)
+				if job == nil {
+					return
+				}
+				q.execute(ctx, jobCtx, job)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// next waits for a queued job and marks it running, with the context that
+// Cancel stops it through registered in the same step. It returns nil once
+// ctx is canceled.
+func (q *JobQueue) next(ctx context.Context) (*models.QueuedJob, context.Context, context.CancelFunc) {
+	for {
+		q.mu.Lock()
+		for _, job := range q.jobs {
+			if job.Status == models.JobQueued {
+				now := time.Now()
+				job.Status = models.JobRunning
+				job.StartedAt = &now
+				job.Stats = models.DownloadStats{}
+				jobCtx, cancel := context.WithCancel(ctx)
+				q.cancels[job.ID] = cancel
+				q.save()
+				q.mu.Unlock()
+				return job, jobCtx, cancel
+			}
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, nil
+		case <-wake:
+		}
+	}
+}
+
+// execute runs a job in jobCtx and records its outcome. ctx tells a
+// shutdown apart from a canceled job.
+func (q *JobQueue) execute(ctx, jobCtx context.Context, job *models.QueuedJob) {
+	q.mu.Lock()
+	spec := job.Job
+	q.mu.Unlock()
+
+	var sink models.EventSink
+	if q.events != nil {
+		sink = events.WithJob(q.events, job.ID)
+		sink.Publish(models.Event{Type: models.EventJobStarted, Time: time.Now()})
+	}
+
+	stats, err := q.runner.RunContext(jobCtx, spec, func(stats models.DownloadStats) {
+		q.mu.Lock()
+		job.Stats = stats
+		q.mu.Unlock()
+	}, sink)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.cancels, job.ID)
+	if stats != nil {
+		job.Stats = *stats
+	}
+
+	switch {
+	case q.canceled[job.ID]:
+		delete(q.canceled, job.ID)
+		job.Status = models.JobCanceled
+	case ctx.Err() != nil:
+		// Shutting down, run the job again after a restart
+		job.Status = models.JobQueued
+		job.StartedAt = nil
+	case err != nil:
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = models.JobDone
+	}
+
+	if job.Status.Finished() {
+		now := time.Now()
+		job.FinishedAt = &now
+	}
+
+	if sink != nil {
+		reason := string(job.Status)
+		if job.Error != "" {
+			reason += ": " + job.Error
+		}
+		finished := job.Stats
+		sink.Publish(models.Event{Type: models.EventJobFinished, Time: time.Now(), Reason: reason, Stats: &finished})
+	}
+
+	q.prune()
+	q.save()
+}
+
+func (q *JobQueue) find(id string) *models.QueuedJob {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// prune drops the oldest finished jobs beyond the history limit
+func (q *JobQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.Status.Finished() {
+			finished++
+		}
+	}
+
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.Status.Finished() && finished > queueHistoryLimit {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+// save writes the queue to disk, replacing the file atomically
+func (q *JobQueue) save() error {
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save job queue: %w", err)
+	}
+	return os.Rename(tmpPath, q.path)
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package services
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates a copy-on-write clone of src at dst on filesystems that
+// support it, such as Btrfs and XFS
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
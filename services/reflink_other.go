@@ -0,0 +1,10 @@
+//go:build !linux
+
+package services
+
+import "fmt"
+
+// reflink is only implemented on Linux
+func reflink(src, dst string) error {
+	return fmt.Errorf("reflinks are not supported on this platform")
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"r34-go/config"
+)
+
+const (
+	siteURL         = "https://rule34.xxx/index.php"
+	autocompleteURL = "https://ac.rule34.xxx/autocomplete.php"
+)
+
+// request builds a request URL. Every query parameter goes through
+// net/url, so tags containing &, +, #, : or parentheses reach the site
+// unchanged.
+type request struct {
+	base  string
+	query url.Values
+}
+
+// newRequest starts a request URL for an endpoint
+func newRequest(base string) *request {
+	return &request{base: base, query: url.Values{}}
+}
+
+// siteRequest starts a request URL for a page of the site
+func siteRequest(page string) *request {
+	return newRequest(siteURL).set("page", page)
+}
+
+// apiRequest starts a request URL for an API index, e.g. "post" or "tag",
+// signed with the configured API credentials
+func apiRequest(s string) *request {
+	r := siteRequest("dapi").set("s", s).set("q", "index")
+	if credentials := config.AppSettings.Credentials; credentials.APIKey != "" {
+		r.set("user_id", credentials.UserID).set("api_key", credentials.APIKey)
+	}
+	return r
+}
+
+// listRequest builds the URL of a post list page at the given post offset
+func listRequest(tags string, pid int) string {
+	r := siteRequest("post").set("s", "list").tags(tags)
+	if pid > 0 {
+		r.setInt("pid", pid)
+	}
+	return r.String()
+}
+
+// poolRequest builds the URL of a pool page
+func poolRequest(id string) string {
+	return siteRequest("pool").set("s", "show").set("id", id).String()
+}
+
+func (r *request) set(key, value string) *request {
+	r.query.Set(key, value)
+	return r
+}
+
+func (r *request) setInt(key string, value int) *request {
+	return r.set(key, strconv.Itoa(value))
+}
+
+// tags sets the tags parameter. The site separates tags by single spaces,
+// so runs of whitespace are collapsed.
+func (r *request) tags(tags string) *request {
+	return r.set("tags", strings.Join(strings.Fields(tags), " "))
+}
+
+// String returns the encoded URL
+func (r *request) String() string {
+	if len(r.query) == 0 {
+		return r.base
+	}
+	return r.base + "?" + r.query.Encode()
+}
+
+// PostURL returns the site page of a post
+func PostURL(id string) string {
+	return siteRequest("post").set("s", "view").set("id", id).String()
+}
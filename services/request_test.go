@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"r34-go/config"
+	"r34-go/query"
+)
+
+func TestListRequestEncodesTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want string
+	}{
+		{"ampersand", "black_&_white", "page=post&s=list&tags=black_%26_white"},
+		{"plus", "c++", "page=post&s=list&tags=c%2B%2B"},
+		{"hash", "c#", "page=post&s=list&tags=c%23"},
+		{"colon", "re:zero_kara_hajimeru_isekai_seikatsu", "page=post&s=list&tags=re%3Azero_kara_hajimeru_isekai_seikatsu"},
+		{"parentheses", "hu_tao_(genshin_impact)", "page=post&s=list&tags=hu_tao_%28genshin_impact%29"},
+		{"accent", "pokémon", "page=post&s=list&tags=pok%C3%A9mon"},
+		{"cjk", "東方", "page=post&s=list&tags=%E6%9D%B1%E6%96%B9"},
+		{"meta and negation", "score:>=10 -ai_generated", "page=post&s=list&tags=score%3A%3E%3D10+-ai_generated"},
+		{"or group", "( a ~ b )", "page=post&s=list&tags=%28+a+~+b+%29"},
+		{"whitespace", "  a   b\t c ", "page=post&s=list&tags=a+b+c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := siteURL + "?" + tt.want
+			if got := listRequest(tt.tags, 0); got != want {
+				t.Errorf("listRequest(%q) = %q, want %q", tt.tags, got, want)
+			}
+		})
+	}
+}
+
+func TestListRequestOffset(t *testing.T) {
+	want := siteURL + "?page=post&pid=42&s=list&tags=a"
+	if got := listRequest("a", 42); got != want {
+		t.Errorf("listRequest with pid = %q, want %q", got, want)
+	}
+}
+
+func TestAPIRequest(t *testing.T) {
+	saved := config.AppSettings.Credentials
+	defer func() { config.AppSettings.Credentials = saved }()
+
+	config.AppSettings.Credentials = config.CredentialSettings{}
+	want := siteURL + "?page=dapi&pid=1&q=index&s=post&tags=fate%2Fgrand_order+a%26b"
+	if got := apiRequest("post").tags("fate/grand_order a&b").setInt("pid", 1).String(); got != want {
+		t.Errorf("apiRequest = %q, want %q", got, want)
+	}
+
+	config.AppSettings.Credentials = config.CredentialSettings{UserID: "12", APIKey: "k&y=1"}
+	want = siteURL + "?api_key=k%26y%3D1&page=dapi&q=index&s=tag&user_id=12"
+	if got := apiRequest("tag").String(); got != want {
+		t.Errorf("signed apiRequest = %q, want %q", got, want)
+	}
+}
+
+func TestCompiledQueryRequest(t *testing.T) {
+	saved := config.AppSettings.Credentials
+	defer func() { config.AppSettings.Credentials = saved }()
+	config.AppSettings.Credentials = config.CredentialSettings{}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"black_&_white", "black_%26_white"},
+		{"c++", "c%2B%2B"},
+		{":)", "%3A%29"},
+		{"hu_tao_(genshin_impact)", "hu_tao_%28genshin_impact%29"},
+		{"black_&_white c++ :)", "black_%26_white+c%2B%2B+%3A%29"},
+		{"hu_tao_(genshin_impact) | c++", "%28+hu_tao_%28genshin_impact%29+~+c%2B%2B+%29"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			plan, err := query.Compile(tt.query)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.query, err)
+			}
+			want := siteURL + "?page=dapi&q=index&s=post&tags=" + tt.want
+			if got := apiRequest("post").tags(plan.Tags).String(); got != want {
+				t.Errorf("request for %q = %q, want %q", tt.query, got, want)
+			}
+		})
+	}
+}
+
+func TestPostURL(t *testing.T) {
+	want := siteURL + "?id=123&page=post&s=view"
+	if got := PostURL("123"); got != want {
+		t.Errorf("PostURL = %q, want %q", got, want)
+	}
+}
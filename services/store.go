@@ -0,0 +1,234 @@
+package services
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+// Link modes supported by the content store
+const (
+	LinkAuto     = "auto"
+	LinkHardlink = "hardlink"
+	LinkSymlink  = "symlink"
+	LinkReflink  = "reflink"
+)
+
+// ContentStore keeps one copy of every file keyed by its MD5 and links
+// output paths to it, so posts shared between jobs are stored once
+type ContentStore struct {
+	root     string
+	linkMode string
+}
+
+// NewContentStore creates a content store rooted at the given directory
+func NewContentStore(root, linkMode string) *ContentStore {
+	if linkMode == "" {
+		linkMode = LinkAuto
+	}
+	return &ContentStore{root: root, linkMode: linkMode}
+}
+
+// Root returns the store directory
+func (cs *ContentStore) Root() string {
+	return cs.root
+}
+
+// Path returns where content with the given MD5 and extension is stored
+func (cs *ContentStore) Path(hash, ext string) string {
+	hash = strings.ToLower(hash)
+	prefix := hash
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return filepath.Join(cs.root, prefix, hash+strings.ToLower(ext))
+}
+
+// Has checks if content with the given MD5 and extension is already stored
+func (cs *ContentStore) Has(hash, ext string) bool {
+	_, err := os.Stat(cs.Path(hash, ext))
+	return err == nil
+}
+
+// storeKey returns the MD5 the file at url is stored under, or an empty
+// string to bypass the store when url is a sample or thumbnail, which is a
+// different file than the one the post's MD5 is of
+func storeKey(post models.Post, url string) string {
+	if url != post.FileURL || strings.Contains(utils.ExtractFilenameFromURL(url), "_") {
+		return ""
+	}
+	return post.MD5
+}
+
+// Link makes target point at the stored file using the configured link mode
+func (cs *ContentStore) Link(storePath, target string) error {
+	_, err := cs.link(storePath, target)
+	return err
+}
+
+// link creates target like Link and returns the link mode it used
+func (cs *ContentStore) link(storePath, target string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+
+	switch cs.linkMode {
+	case LinkHardlink:
+		return LinkHardlink, os.Link(storePath, target)
+	case LinkSymlink:
+		return LinkSymlink, symlinkAbs(storePath, target)
+	case LinkReflink:
+		return LinkReflink, reflink(storePath, target)
+	case LinkAuto:
+		// Hardlinks fail across filesystems, so fall back to the other modes
+		if err := os.Link(storePath, target); err == nil {
+			return LinkHardlink, nil
+		}
+		if err := reflink(storePath, target); err == nil {
+			return LinkReflink, nil
+		}
+		return LinkSymlink, symlinkAbs(storePath, target)
+	default:
+		return "", fmt.Errorf("unknown link mode %q", cs.linkMode)
+	}
+}
+
+// Ingest moves an existing file into the store and replaces it with a link.
+// The link is made under a temporary name and renamed over the file, so the
+// file stays as it was when linking fails. It returns the number of bytes
+// reclaimed, which is the file size when the content was already stored
+// and is now shared, and zero otherwise. Reflinks are copies as far as the
+// used space shows, so they reclaim nothing.
+func (cs *ContentStore) Ingest(filePath string) (int64, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, nil // Already a symlink or not a file
+	}
+
+	hash, err := FileMD5(filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	storePath := cs.Path(hash, filepath.Ext(filePath))
+	stored := false
+	if storeInfo, err := os.Stat(storePath); err == nil {
+		if os.SameFile(info, storeInfo) {
+			return 0, nil // Already linked into the store
+		}
+		stored = true
+	} else {
+		// A hardlink stores the file without a copy and is the link too
+		if cs.linkMode == LinkAuto || cs.linkMode == LinkHardlink {
+			if err := os.MkdirAll(filepath.Dir(storePath), 0755); err != nil {
+				return 0, err
+			}
+			if err := os.Link(filePath, storePath); err == nil {
+				return 0, nil
+			}
+		}
+		if err := copyFile(filePath, storePath); err != nil {
+			return 0, err
+		}
+	}
+
+	tmp := filePath + ".store.tmp"
+	os.Remove(tmp) // Left over by an interrupted run
+	mode, err := cs.link(storePath, tmp)
+	if err == nil {
+		err = os.Rename(tmp, filePath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		if !stored {
+			os.Remove(storePath)
+		}
+		return 0, fmt.Errorf("failed to link %s to content store: %w", filePath, err)
+	}
+
+	if !stored || mode == LinkReflink {
+		return 0, nil
+	}
+	return info.Size(), nil
+}
+
+// FileMD5 calculates the MD5 of a file as a lowercase hex string
+func FileMD5(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func symlinkAbs(storePath, target string) error {
+	absPath, err := filepath.Abs(storePath)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(absPath, target)
+}
+
+// moveFile renames a file, copying it when source and destination are on
+// different filesystems
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst through a temporary file, so dst never holds
+// part of a file
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	out.Chmod(0644)
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
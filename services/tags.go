@@ -0,0 +1,365 @@
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"r34-go/config"
+	"r34-go/models"
+)
+
+const (
+	tagCacheFile  = "tag_cache.json"
+	tagCacheTTL   = 30 * 24 * time.Hour
+	tagBatchLimit = 100
+)
+
+// cachedTag is a tag entry in the on-disk tag cache
+type cachedTag struct {
+	Type      models.TagType `json:"type"`
+	Count     int            `json:"count"`
+	FetchedAt time.Time      `json:"fetched_at"`
+}
+
+// TagService resolves tag categories through the tag API and caches them
+type TagService struct {
+	client    *Client
+	cachePath string
+
+	mu    sync.Mutex
+	cache map[string]cachedTag
+	dirty bool
+}
+
+// NewTagService creates a new tag service instance
+func NewTagService() *TagService {
+	return NewTagServiceWithClient(NewClient())
+}
+
+// NewTagServiceWithClient creates a new tag service instance sharing the given client
+func NewTagServiceWithClient(client *Client) *TagService {
+	ts := &TagService{
+		client:    client,
+		cachePath: filepath.Join(config.CacheDir(), tagCacheFile),
+		cache:     make(map[string]cachedTag),
+	}
+	ts.loadCache()
+	return ts
+}
+
+// Lookup returns the type and post count of the given tags, fetching the
+// ones that aren't cached
+func (ts *TagService) Lookup(names []string) (map[string]models.Tag, error) {
+	result := make(map[string]models.Tag, len(names))
+	queued := make(map[string]bool)
+	var missing []string
+
+	ts.mu.Lock()
+	for _, name := range names {
+		if cached, ok := ts.cache[name]; ok && time.Since(cached.FetchedAt) < tagCacheTTL {
+			result[name] = models.Tag{Name: name, Type: cached.Type, Count: cached.Count}
+		} else if !queued[name] {
+			queued[name] = true
+			missing = append(missing, name)
+		}
+	}
+	ts.mu.Unlock()
+
+	for start := 0; start < len(missing); start += tagBatchLimit {
+		end := start + tagBatchLimit
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		fetched, err := ts.fetchTags(missing[start:end])
+		if err != nil {
+			return result, err
+		}
+		for name, tag := range fetched {
+			result[name] = tag
+		}
+	}
+
+	return result, nil
+}
+
+// CategorizePosts fills in the tag groups of every post. When a lookup
+// fails, posts with unresolved tags keep no groups rather than wrong ones.
+func (ts *TagService) CategorizePosts(posts []models.Post) error {
+	seen := make(map[string]bool)
+	var names []string
+	for _, post := range posts {
+		for _, tag := range post.TagList() {
+			if !seen[tag] {
+				seen[tag] = true
+				names = append(names, tag)
+			}
+		}
+	}
+
+	tags, err := ts.Lookup(names)
+
+	// Group the posts whose tags were all resolved even if some lookups
+	// failed. Tags missing after a successful lookup are unknown to the site.
+	for i := range posts {
+		groups := &models.TagGroups{}
+		for _, name := range posts[i].TagList() {
+			tag, ok := tags[name]
+			if !ok && err != nil {
+				groups = nil
+				break
+			}
+			groups.Add(name, tag.Type)
+		}
+		posts[i].Groups = groups
+	}
+
+	ts.SaveCache()
+	return err
+}
+
+// SaveCache writes the tag cache to disk if it changed
+func (ts *TagService) SaveCache() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(ts.cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ts.cachePath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(ts.cachePath, data, 0644); err != nil {
+		return err
+	}
+
+	ts.dirty = false
+	return nil
+}
+
+func (ts *TagService) loadCache() {
+	data, err := os.ReadFile(ts.cachePath)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &ts.cache)
+}
+
+// fetchTags queries the tag API for a batch of names. Tags the API doesn't
+// know about are cached as general tags so they aren't requested again.
+func (ts *TagService) fetchTags(names []string) (map[string]models.Tag, error) {
+	requestURL := apiRequest("tag").setInt("limit", len(names)).set("names", strings.Join(names, " ")).String()
+
+	resp, err := ts.client.Get(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tag types: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tagResp models.TagResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&tagResp); err != nil {
+		return nil, fmt.Errorf("failed to decode tag XML response: %w", err)
+	}
+
+	result := make(map[string]models.Tag, len(names))
+	for _, tag := range tagResp.Tags {
+		result[tag.Name] = tag
+	}
+	for _, name := range names {
+		if _, ok := result[name]; !ok {
+			result[name] = models.Tag{Name: name, Type: models.TagGeneral}
+		}
+	}
+
+	now := time.Now()
+	ts.mu.Lock()
+	for name, tag := range result {
+		ts.cache[name] = cachedTag{Type: tag.Type, Count: tag.Count, FetchedAt: now}
+	}
+	ts.dirty = true
+	ts.mu.Unlock()
+
+	return result, nil
+}
+
+// autocompleteEntry is one suggestion from the site autocomplete endpoint
+type autocompleteEntry struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// Suggest returns tags starting with prefix using the site autocomplete
+func (ts *TagService) Suggest(prefix string, limit int) ([]models.Tag, error) {
+	resp, err := ts.client.Get(newRequest(autocompleteURL).set("q", prefix).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch suggestions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []autocompleteEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode suggestions: %w", err)
+	}
+
+	var tags []models.Tag
+	for _, entry := range entries {
+		if entry.Value == "" {
+			continue
+		}
+		tags = append(tags, models.Tag{
+			Name:  entry.Value,
+			Type:  models.ParseTagType(entry.Type),
+			Count: parseLabelCount(entry.Label),
+		})
+		if limit > 0 && len(tags) >= limit {
+			break
+		}
+	}
+
+	ts.remember(tags)
+	return tags, nil
+}
+
+// remember caches tags seen in autocomplete results so they can be completed
+// offline. Existing entries are kept, since the tag API knows categories
+// better than the autocomplete does.
+func (ts *TagService) remember(tags []models.Tag) {
+	now := time.Now()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, tag := range tags {
+		if _, ok := ts.cache[tag.Name]; ok {
+			continue
+		}
+		ts.cache[tag.Name] = cachedTag{Type: tag.Type, Count: tag.Count, FetchedAt: now}
+		ts.dirty = true
+	}
+}
+
+// Cached returns cached tags starting with prefix, most used first. It never
+// makes a request, so it is safe to call from shell completion.
+func (ts *TagService) Cached(prefix string, limit int) []models.Tag {
+	prefix = strings.ToLower(prefix)
+
+	ts.mu.Lock()
+	var tags []models.Tag
+	for name, cached := range ts.cache {
+		// Unknown tags are cached with no posts and aren't worth completing
+		if cached.Count == 0 || !strings.HasPrefix(strings.ToLower(name), prefix) {
+			continue
+		}
+		tags = append(tags, models.Tag{Name: name, Type: cached.Type, Count: cached.Count})
+	}
+	ts.mu.Unlock()
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags
+}
+
+// DidYouMean suggests replacements for a tag that matched nothing, trying
+// shorter prefixes until the autocomplete finds something
+func (ts *TagService) DidYouMean(tag string, limit int) ([]models.Tag, error) {
+	tag = strings.TrimPrefix(tag, "-")
+	for length := len(tag); length >= 3; length = length * 2 / 3 {
+		suggestions, err := ts.Suggest(tag[:length], limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(suggestions) > 0 {
+			return suggestions, nil
+		}
+	}
+	return nil, nil
+}
+
+// Related counts the tags that appear together with tag on the first pages
+// of its search results and returns the most frequent ones
+func (ts *TagService) Related(tag string, pages, limit int) ([]models.RelatedTag, int, error) {
+	apiService := NewAPIServiceWithClient(ts.client)
+
+	counts := make(map[string]int)
+	sampled := 0
+	for pid := 0; pid < pages; pid++ {
+		posts, err := apiService.GetPosts(tag, pid, pageSize)
+		if err != nil {
+			return nil, sampled, err
+		}
+		if len(posts) == 0 {
+			break
+		}
+
+		for _, post := range posts {
+			for _, name := range post.TagList() {
+				if name != tag {
+					counts[name]++
+				}
+			}
+		}
+		sampled += len(posts)
+	}
+
+	related := make([]models.RelatedTag, 0, len(counts))
+	for name, together := range counts {
+		related = append(related, models.RelatedTag{Tag: models.Tag{Name: name}, Together: together})
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Together != related[j].Together {
+			return related[i].Together > related[j].Together
+		}
+		return related[i].Name < related[j].Name
+	})
+	if limit > 0 && len(related) > limit {
+		related = related[:limit]
+	}
+
+	// Fill in total post counts and categories, which are cached
+	names := make([]string, len(related))
+	for i, r := range related {
+		names[i] = r.Name
+	}
+	if info, err := ts.Lookup(names); err == nil {
+		for i := range related {
+			related[i].Type = info[related[i].Name].Type
+			related[i].Count = info[related[i].Name].Count
+		}
+	}
+	ts.SaveCache()
+
+	return related, sampled, nil
+}
+
+// parseLabelCount extracts the post count from labels like "tag_name (1234)"
+func parseLabelCount(label string) int {
+	open := strings.LastIndex(label, "(")
+	if open < 0 || !strings.HasSuffix(label, ")") {
+		return 0
+	}
+	count, err := strconv.Atoi(label[open+1 : len(label)-1])
+	if err != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"r34-go/config"
+	"r34-go/models"
+	"r34-go/utils"
+)
+
+const viewsDir = "views"
+
+// ViewBuilder creates by-tag, by-artist and by-rating directory trees of
+// symlinks pointing at the files of a library
+type ViewBuilder struct {
+	library  *Library
+	settings config.ViewSettings
+}
+
+// NewViewBuilder creates a view builder for a library
+func NewViewBuilder(library *Library, settings config.ViewSettings) *ViewBuilder {
+	return &ViewBuilder{library: library, settings: settings}
+}
+
+// Build removes the existing views and links every library entry again.
+// It returns the number of links created.
+func (vb *ViewBuilder) Build() (int, error) {
+	if err := os.RemoveAll(filepath.Join(vb.library.Dir(), viewsDir)); err != nil {
+		return 0, fmt.Errorf("failed to remove old views: %w", err)
+	}
+
+	entries := vb.library.Entries()
+	counts := tagCounts(entries)
+
+	links := 0
+	for _, entry := range entries {
+		for _, view := range vb.viewPaths(entry, counts) {
+			created, err := vb.link(view, entry)
+			if err != nil {
+				return links, err
+			}
+			if created {
+				links++
+			}
+		}
+	}
+
+	return links, nil
+}
+
+// Update links newly downloaded entries. Every view touched by a new entry
+// is filled completely, so tags that just reached the minimum post count
+// also get links for older files.
+func (vb *ViewBuilder) Update(newEntries []models.LibraryEntry) (int, error) {
+	if len(newEntries) == 0 {
+		return 0, nil
+	}
+
+	entries := vb.library.Entries()
+	counts := tagCounts(entries)
+
+	affected := make(map[string]bool)
+	for _, entry := range newEntries {
+		for _, view := range vb.viewPaths(entry, counts) {
+			affected[view] = true
+		}
+	}
+
+	links := 0
+	for _, entry := range entries {
+		for _, view := range vb.viewPaths(entry, counts) {
+			if !affected[view] {
+				continue
+			}
+			created, err := vb.link(view, entry)
+			if err != nil {
+				return links, err
+			}
+			if created {
+				links++
+			}
+		}
+	}
+
+	return links, nil
+}
+
+// viewPaths returns the view directories, relative to the views root, that
+// an entry belongs to
+func (vb *ViewBuilder) viewPaths(entry models.LibraryEntry, counts map[string]int) []string {
+	var views []string
+	tags := entry.Post.TagList()
+
+	if vb.settings.ByTag {
+		for _, tag := range tags {
+			if vb.includeTag(tag, counts) {
+				views = append(views, filepath.Join("by-tag", utils.SanitizeFilename(tag)))
+			}
+		}
+	}
+
+	if vb.settings.ByArtist {
+		for _, artist := range vb.artists(entry.Post) {
+			views = append(views, filepath.Join("by-artist", utils.SanitizeFilename(artist)))
+		}
+	}
+
+	if vb.settings.ByRating && entry.Post.Rating != "" {
+		views = append(views, filepath.Join("by-rating", RatingName(entry.Post.Rating)))
+	}
+
+	return views
+}
+
+func (vb *ViewBuilder) includeTag(tag string, counts map[string]int) bool {
+	if len(vb.settings.Tags) > 0 {
+		for _, included := range vb.settings.Tags {
+			if strings.EqualFold(tag, included) {
+				return true
+			}
+		}
+		return false
+	}
+	return counts[tag] >= vb.settings.MinCount
+}
+
+func (vb *ViewBuilder) artists(post models.Post) []string {
+	var artists []string
+	if post.Groups != nil {
+		artists = append(artists, post.Groups.Artist...)
+	}
+
+	for _, tag := range post.TagList() {
+		if containsTag(artists, tag) {
+			continue
+		}
+		for _, artist := range vb.settings.Artists {
+			if strings.EqualFold(tag, artist) {
+				artists = append(artists, tag)
+				break
+			}
+		}
+	}
+	return artists
+}
+
+// link creates a relative symlink to the entry's file inside a view,
+// returning false if it already exists
+func (vb *ViewBuilder) link(view string, entry models.LibraryEntry) (bool, error) {
+	dir := vb.library.Dir()
+	linkDir := filepath.Join(dir, viewsDir, view)
+	filePath := filepath.Join(dir, filepath.FromSlash(entry.Path))
+	linkPath := filepath.Join(linkDir, filepath.Base(filePath))
+
+	if _, err := os.Lstat(linkPath); err == nil {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(linkDir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create view %s: %w", view, err)
+	}
+
+	target, err := filepath.Rel(linkDir, filePath)
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Symlink(target, linkPath); err != nil {
+		return false, fmt.Errorf("failed to link %s: %w", linkPath, err)
+	}
+
+	return true, nil
+}
+
+// RatingName returns the full name of a rating such as "e" or "explicit"
+func RatingName(rating string) string {
+	switch strings.ToLower(rating) {
+	case "s", "safe", "g", "general":
+		return "safe"
+	case "q", "questionable":
+		return "questionable"
+	case "e", "explicit":
+		return "explicit"
+	default:
+		return strings.ToLower(rating)
+	}
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func tagCounts(entries []models.LibraryEntry) map[string]int {
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		for _, tag := range entry.Post.TagList() {
+			counts[tag]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"r34-go/config"
+	"r34-go/models"
+)
+
+const watchStateFile = "watch_state.json"
+
+// WatchState persists the last run of every subscription, keyed by name
+type WatchState struct {
+	path string
+
+	mu            sync.Mutex
+	subscriptions map[string]models.SubscriptionState
+}
+
+// WatchStatePath returns the default location of the watch state file
+func WatchStatePath() string {
+	return filepath.Join(config.DataDir(), watchStateFile)
+}
+
+// LoadWatchState reads the watch state stored at path. A missing file gives
+// an empty state.
+func LoadWatchState(path string) (*WatchState, error) {
+	state := &WatchState{
+		path:          path,
+		subscriptions: make(map[string]models.SubscriptionState),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state.subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to parse watch state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Get returns the state of a subscription
+func (ws *WatchState) Get(name string) models.SubscriptionState {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.subscriptions[name]
+}
+
+// Update changes the state of a subscription and saves the state file
+func (ws *WatchState) Update(name string, update func(state *models.SubscriptionState)) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	state := ws.subscriptions[name]
+	update(&state)
+	ws.subscriptions[name] = state
+	return ws.save()
+}
+
+// save writes the state to disk, replacing the file atomically
+func (ws *WatchState) save() error {
+	data, err := json.MarshalIndent(ws.subscriptions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ws.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := ws.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save watch state: %w", err)
+	}
+	return os.Rename(tmpPath, ws.path)
+}
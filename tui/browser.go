@@ -0,0 +1,508 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"r34-go/models"
+	"r34-go/services"
+	"r34-go/utils"
+)
+
+// Number of screen lines used by the header, post details and help line
+const (
+	headerLines = 1
+	footerLines = 4
+)
+
+// PageSource fetches a page of posts. end reports that no pages follow.
+type PageSource func(page int) (posts []models.Post, end bool, err error)
+
+// action is what the browser does after a key press
+type action int
+
+const (
+	actionNone action = iota
+	actionQuit
+	actionDownload
+)
+
+// previewEntry is the preview image of a post, fetched in the background
+type previewEntry struct {
+	img     image.Image
+	err     error
+	loading bool
+
+	// encoded is the escape sequence for the last drawn preview size
+	encoded    string
+	cols, rows int
+}
+
+// previewResult is a finished preview download
+type previewResult struct {
+	id  string
+	img image.Image
+	err error
+}
+
+// Browser is a full-screen list of posts for picking posts by hand
+type Browser struct {
+	title   string
+	source  PageSource
+	client  *services.Client
+	preview PreviewMode
+
+	page        int
+	posts       []models.Post
+	end         bool
+	cursor      int
+	offset      int
+	status      string
+	marked      map[string]models.Post
+	order       []string
+	showPreview bool
+
+	previews    map[string]*previewEntry
+	previewDone chan previewResult
+}
+
+// NewBrowser creates a browser over the pages of a post source. Previews are
+// fetched with client and drawn using the given mode.
+func NewBrowser(title string, source PageSource, client *services.Client, preview PreviewMode) *Browser {
+	return &Browser{
+		title:       title,
+		source:      source,
+		client:      client,
+		preview:     preview,
+		marked:      make(map[string]models.Post),
+		showPreview: preview != PreviewNone,
+		previews:    make(map[string]*previewEntry),
+		previewDone: make(chan previewResult, 64),
+	}
+}
+
+// Run shows the browser until the user quits. It returns the marked posts
+// when the user chose to download them, or nil when quitting.
+func (b *Browser) Run() ([]models.Post, error) {
+	if err := b.loadPage(0); err != nil {
+		return nil, err
+	}
+	if len(b.posts) == 0 && b.end {
+		return nil, fmt.Errorf("no posts found")
+	}
+
+	t, err := openTerminal()
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+
+	keys := make(chan keyEvent)
+	go t.readKeys(keys)
+
+	for {
+		b.draw(t)
+
+		select {
+		case ev, ok := <-keys:
+			if !ok {
+				return nil, nil
+			}
+			switch b.handleKey(ev, t) {
+			case actionQuit:
+				return nil, nil
+			case actionDownload:
+				return b.markedPosts(), nil
+			}
+		case res := <-b.previewDone:
+			b.previews[res.id] = &previewEntry{img: res.img, err: res.err}
+		}
+	}
+}
+
+// handleKey applies a key press
+func (b *Browser) handleKey(ev keyEvent, t *terminal) action {
+	b.status = ""
+
+	key := ev.key
+	if key == KeyRune {
+		switch ev.rune {
+		case 'k':
+			key = KeyUp
+		case 'j':
+			key = KeyDown
+		case 'h', 'p':
+			key = KeyLeft
+		case 'l', 'n':
+			key = KeyRight
+		case 'g':
+			key = KeyHome
+		case 'G':
+			key = KeyEnd
+		}
+	}
+
+	switch key {
+	case KeyUp:
+		b.moveCursor(-1)
+	case KeyDown:
+		b.moveCursor(1)
+	case KeyHome:
+		b.cursor = 0
+	case KeyEnd:
+		b.cursor = len(b.posts) - 1
+	case KeyLeft, KeyPageUp:
+		b.changePage(b.page-1, t)
+	case KeyRight, KeyPageDown:
+		b.changePage(b.page+1, t)
+	case KeyEnter:
+		b.openCurrent()
+	case KeyEscape, KeyInterrupt:
+		return actionQuit
+	case KeyRune:
+		switch ev.rune {
+		case 'q':
+			return actionQuit
+		case ' ':
+			if post, ok := b.current(); ok {
+				b.toggleMark(post)
+				b.moveCursor(1)
+			}
+		case 'a':
+			b.toggleAll()
+		case 'o':
+			b.openCurrent()
+		case 'v':
+			if b.preview == PreviewNone {
+				b.status = "No inline image support detected, use --preview kitty or --preview sixel"
+			} else {
+				b.showPreview = !b.showPreview
+			}
+		case 'd':
+			if len(b.order) == 0 {
+				b.status = "Mark posts with space before downloading"
+				break
+			}
+			return actionDownload
+		}
+	}
+
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	return actionNone
+}
+
+// loadPage replaces the shown posts with another page
+func (b *Browser) loadPage(page int) error {
+	posts, end, err := b.source(page)
+	if err != nil {
+		return fmt.Errorf("failed to load page %d: %w", page+1, err)
+	}
+
+	b.page = page
+	b.posts = posts
+	b.end = end
+	b.cursor = 0
+	b.offset = 0
+	return nil
+}
+
+func (b *Browser) changePage(page int, t *terminal) {
+	if page < 0 {
+		b.status = "Already on the first page"
+		return
+	}
+	if page > b.page && b.end {
+		b.status = "No more pages"
+		return
+	}
+
+	b.status = fmt.Sprintf("Loading page %d...", page+1)
+	b.draw(t)
+
+	if err := b.loadPage(page); err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.status = ""
+}
+
+func (b *Browser) moveCursor(delta int) {
+	b.cursor += delta
+	if b.cursor >= len(b.posts) {
+		b.cursor = len(b.posts) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+func (b *Browser) current() (models.Post, bool) {
+	if b.cursor < 0 || b.cursor >= len(b.posts) {
+		return models.Post{}, false
+	}
+	return b.posts[b.cursor], true
+}
+
+func (b *Browser) toggleMark(post models.Post) {
+	if _, ok := b.marked[post.ID]; ok {
+		delete(b.marked, post.ID)
+		for i, id := range b.order {
+			if id == post.ID {
+				b.order = append(b.order[:i], b.order[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	b.marked[post.ID] = post
+	b.order = append(b.order, post.ID)
+}
+
+// toggleAll marks every post on the page, or unmarks them if all are marked
+func (b *Browser) toggleAll() {
+	allMarked := true
+	for _, post := range b.posts {
+		if _, ok := b.marked[post.ID]; !ok {
+			allMarked = false
+			break
+		}
+	}
+
+	for _, post := range b.posts {
+		if _, ok := b.marked[post.ID]; ok == allMarked {
+			b.toggleMark(post)
+		}
+	}
+}
+
+// markedPosts returns the marked posts in the order they were marked
+func (b *Browser) markedPosts() []models.Post {
+	posts := make([]models.Post, 0, len(b.order))
+	for _, id := range b.order {
+		posts = append(posts, b.marked[id])
+	}
+	return posts
+}
+
+func (b *Browser) openCurrent() {
+	post, ok := b.current()
+	if !ok {
+		return
+	}
+
+	url := services.PostURL(post.ID)
+	if err := openURL(url); err != nil {
+		b.status = fmt.Sprintf("Failed to open %s: %v", url, err)
+		return
+	}
+	b.status = "Opened " + url
+}
+
+// draw renders the whole screen
+func (b *Browser) draw(t *terminal) {
+	width, height := t.size()
+	listHeight := height - headerLines - footerLines
+	if listHeight < 1 {
+		listHeight = 1
+	}
+
+	listWidth, previewCols := width, 0
+	if b.showPreview && width >= 60 {
+		previewCols = width / 3
+		listWidth = width - previewCols - 1
+	}
+
+	// Keep the cursor on screen
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+listHeight {
+		b.offset = b.cursor - listHeight + 1
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(clearPreviews(b.preview))
+	buf.WriteString("\x1b[H\x1b[2J")
+
+	header := fmt.Sprintf(" %s  |  page %d  |  %d posts  |  %d marked", b.title, b.page+1, len(b.posts), len(b.order))
+	writeAt(&buf, 1, 1, "\x1b[7m"+fit(header, width)+"\x1b[0m")
+
+	if len(b.posts) == 0 {
+		writeAt(&buf, headerLines+1, 1, " No matching posts on this page")
+	}
+	for row := 0; row < listHeight; row++ {
+		i := b.offset + row
+		if i >= len(b.posts) {
+			break
+		}
+		line := fit(b.formatPost(b.posts[i], i == b.cursor), listWidth)
+		if i == b.cursor {
+			line = "\x1b[1;7m" + line + "\x1b[0m"
+		}
+		writeAt(&buf, headerLines+1+row, 1, line)
+	}
+
+	detailsTop := height - footerLines + 1
+	if post, ok := b.current(); ok {
+		details := fmt.Sprintf(" #%s  score %d  %s  %dx%d  %s", post.ID, post.Score, services.RatingName(post.Rating), post.Width, post.Height, services.PostURL(post.ID))
+		writeAt(&buf, detailsTop, 1, "\x1b[1m"+fit(details, width)+"\x1b[0m")
+		for i, line := range wrapWords(post.Tags, width-2, footerLines-2) {
+			writeAt(&buf, detailsTop+1+i, 2, line)
+		}
+
+		if previewCols > 0 {
+			b.drawPreview(&buf, post, listWidth+2, previewCols, listHeight)
+		}
+	}
+
+	help := " up/down move  left/right page  space mark  a mark page  o open  v preview  d download marked  q quit"
+	if b.status != "" {
+		help = " " + b.status
+	}
+	writeAt(&buf, height, 1, "\x1b[7m"+fit(help, width)+"\x1b[0m")
+
+	t.write(buf.Bytes())
+}
+
+// drawPreview draws the preview of post in the box starting at col
+func (b *Browser) drawPreview(buf *bytes.Buffer, post models.Post, col, cols, rows int) {
+	entry := b.previews[post.ID]
+	if entry == nil {
+		entry = &previewEntry{loading: true}
+		b.previews[post.ID] = entry
+		go b.fetchPreview(post)
+	}
+
+	switch {
+	case entry.loading:
+		writeAt(buf, headerLines+1, col, "Loading preview...")
+	case entry.err != nil:
+		writeAt(buf, headerLines+1, col, fit("Preview failed: "+entry.err.Error(), cols))
+	default:
+		if entry.cols != cols || entry.rows != rows {
+			encoded, err := encodePreview(entry.img, b.preview, cols, rows)
+			if err != nil {
+				entry.err = err
+				return
+			}
+			entry.encoded, entry.cols, entry.rows = encoded, cols, rows
+		}
+		writeAt(buf, headerLines+1, col, entry.encoded)
+	}
+}
+
+// fetchPreview downloads and decodes the preview image of a post
+func (b *Browser) fetchPreview(post models.Post) {
+	result := previewResult{id: post.ID}
+	defer func() { b.previewDone <- result }()
+
+	url := post.PreviewURL
+	if url == "" {
+		url = post.SampleURL
+	}
+	if url == "" {
+		result.err = fmt.Errorf("post has no preview")
+		return
+	}
+
+	resp, err := b.client.Get(url)
+	if err != nil {
+		result.err = err
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		result.err = fmt.Errorf("bad status: %s", resp.Status)
+		return
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.err = err
+		return
+	}
+	result.img, result.err = decodePreview(data)
+}
+
+// formatPost returns the list line of a post
+func (b *Browser) formatPost(post models.Post, selected bool) string {
+	pointer := "  "
+	if selected {
+		pointer = "> "
+	}
+	mark := "[ ]"
+	if _, ok := b.marked[post.ID]; ok {
+		mark = "[x]"
+	}
+
+	return fmt.Sprintf("%s%s %-9s %6d  %-12s %9s  %-5s %s",
+		pointer, mark, post.ID, post.Score, services.RatingName(post.Rating),
+		fmt.Sprintf("%dx%d", post.Width, post.Height), utils.GetFileExtension(post.FileURL), post.Tags)
+}
+
+// writeAt writes text starting at a screen position
+func writeAt(buf *bytes.Buffer, row, col int, text string) {
+	fmt.Fprintf(buf, "\x1b[%d;%dH%s", row, col, text)
+}
+
+// fit pads or truncates text to exactly width cells
+func fit(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		if width <= 1 {
+			return string(runes[:max(width, 0)])
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+// wrapWords wraps space separated words into at most maxLines lines,
+// ending with "…" when words are left over
+func wrapWords(text string, width, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+			if len(lines) == maxLines-1 {
+				return append(lines, fit(line+" "+word, width))
+			}
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// openURL opens a URL in the default browser
+func openURL(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
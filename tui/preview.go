@@ -0,0 +1,240 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+
+	// Preview images are JPEG, PNG or GIF thumbnails
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// PreviewMode selects how post previews are drawn in the terminal
+type PreviewMode string
+
+// Preview modes
+const (
+	PreviewAuto  PreviewMode = "auto"
+	PreviewKitty PreviewMode = "kitty"
+	PreviewSixel PreviewMode = "sixel"
+	PreviewNone  PreviewMode = "none"
+)
+
+// Assumed size of a terminal cell in pixels, used to fit previews
+const (
+	cellWidth  = 10
+	cellHeight = 20
+)
+
+// ParsePreviewMode validates a preview mode, resolving auto from the
+// environment
+func ParsePreviewMode(mode string) (PreviewMode, error) {
+	switch PreviewMode(mode) {
+	case PreviewAuto:
+		return DetectPreview(), nil
+	case PreviewKitty, PreviewSixel, PreviewNone:
+		return PreviewMode(mode), nil
+	default:
+		return PreviewNone, fmt.Errorf("invalid preview mode %q (expected auto, kitty, sixel or none)", mode)
+	}
+}
+
+// DetectPreview guesses the inline image support of the terminal. Sixel
+// support can't be detected reliably without querying the terminal, so it
+// has to be asked for explicitly.
+func DetectPreview() PreviewMode {
+	if os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty") {
+		return PreviewKitty
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "WezTerm", "ghostty":
+		return PreviewKitty
+	}
+	return PreviewNone
+}
+
+// decodePreview decodes a downloaded preview image
+func decodePreview(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode preview: %w", err)
+	}
+	return img, nil
+}
+
+// encodePreview returns the escape sequence drawing img in a box of
+// cols x rows cells at the cursor position
+func encodePreview(img image.Image, mode PreviewMode, cols, rows int) (string, error) {
+	width, height := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), cols*cellWidth, rows*cellHeight)
+	if width == 0 || height == 0 {
+		return "", nil
+	}
+
+	switch mode {
+	case PreviewKitty:
+		return kittyImage(img, (width+cellWidth-1)/cellWidth, (height+cellHeight-1)/cellHeight)
+	case PreviewSixel:
+		return sixelImage(scaleImage(img, width, height)), nil
+	default:
+		return "", nil
+	}
+}
+
+// clearPreviews returns the escape sequence removing drawn previews
+func clearPreviews(mode PreviewMode) string {
+	if mode == PreviewKitty {
+		return "\x1b_Ga=d,d=A,q=2\x1b\\"
+	}
+	// Sixel images are removed by clearing the screen
+	return ""
+}
+
+// fitSize scales width x height down to fit maxWidth x maxHeight, keeping
+// the aspect ratio
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	if width*maxHeight > height*maxWidth {
+		return maxWidth, max(1, height*maxWidth/width)
+	}
+	return max(1, width*maxHeight/height), maxHeight
+}
+
+// kittyImage encodes img with the kitty graphics protocol, letting the
+// terminal scale it to cols x rows cells
+func kittyImage(img image.Image, cols, rows int) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	// The payload is sent in chunks of at most 4096 bytes
+	var out strings.Builder
+	for first := true; data != ""; first = false {
+		chunk := data
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		data = data[len(chunk):]
+
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&out, "\x1b_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&out, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return out.String(), nil
+}
+
+// scaleImage resizes img to width x height with nearest-neighbour sampling
+func scaleImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		srcY := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			srcX := bounds.Min.X + x*bounds.Dx()/width
+			scaled.Set(x, y, img.At(srcX, srcY))
+		}
+	}
+	return scaled
+}
+
+// sixelImage encodes img as sixel graphics using a 6x6x6 colour cube
+func sixelImage(img *image.RGBA) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Map every pixel to a palette index
+	pixels := make([]int, width*height)
+	used := make(map[int]bool)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			index := int(c.R)*5/255*36 + int(c.G)*5/255*6 + int(c.B)*5/255
+			pixels[y*width+x] = index
+			used[index] = true
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", width, height)
+	for index := 0; index < 216; index++ {
+		if used[index] {
+			r, g, b := index/36, index/6%6, index%6
+			fmt.Fprintf(&out, "#%d;2;%d;%d;%d", index, r*100/5, g*100/5, b*100/5)
+		}
+	}
+
+	// Each sixel row covers six pixel rows; every colour present in the
+	// band is drawn in its own pass over it
+	for top := 0; top < height; top += 6 {
+		bandColors := make(map[int]bool)
+		for y := top; y < top+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				bandColors[pixels[y*width+x]] = true
+			}
+		}
+
+		first := true
+		for index := 0; index < 216; index++ {
+			if !bandColors[index] {
+				continue
+			}
+			if !first {
+				out.WriteByte('$')
+			}
+			first = false
+
+			fmt.Fprintf(&out, "#%d", index)
+			var run byte
+			count := 0
+			for x := 0; x < width; x++ {
+				var bits byte
+				for bit := 0; bit < 6 && top+bit < height; bit++ {
+					if pixels[(top+bit)*width+x] == index {
+						bits |= 1 << bit
+					}
+				}
+				char := '?' + bits
+				if char == run {
+					count++
+					continue
+				}
+				writeSixelRun(&out, run, count)
+				run, count = char, 1
+			}
+			writeSixelRun(&out, run, count)
+		}
+		out.WriteByte('-')
+	}
+
+	out.WriteString("\x1b\\")
+	return out.String()
+}
+
+// writeSixelRun writes a repeated sixel character, run-length encoded when
+// that is shorter
+func writeSixelRun(out *strings.Builder, char byte, count int) {
+	switch {
+	case count == 0:
+	case count > 3:
+		fmt.Fprintf(out, "!%d%c", count, char)
+	default:
+		out.WriteString(strings.Repeat(string(char), count))
+	}
+}
@@ -0,0 +1,188 @@
+// Package tui implements the full-screen terminal interface used to browse
+// posts. It draws with plain ANSI escape sequences on a raw-mode terminal.
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Key is a key press decoded from terminal input
+type Key int
+
+// Keys the browser reacts to. Printable characters are KeyRune.
+const (
+	KeyNone Key = iota
+	KeyRune
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPageUp
+	KeyPageDown
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeyEscape
+	KeyInterrupt
+)
+
+// keyEvent is a single key press
+type keyEvent struct {
+	key  Key
+	rune rune
+}
+
+// terminal is the controlling terminal switched to raw mode and the
+// alternate screen
+type terminal struct {
+	in    *os.File
+	out   *os.File
+	state *term.State
+	// keys is where key presses are read from. It is the terminal opened
+	// again where possible, as closing it ends a pending read while closing
+	// stdin would not.
+	keys *os.File
+	done chan struct{}
+}
+
+// openTerminal takes over the terminal for full-screen drawing
+func openTerminal() (*terminal, error) {
+	in, out := os.Stdin, os.Stdout
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return nil, fmt.Errorf("browse needs an interactive terminal")
+	}
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to switch terminal to raw mode: %w", err)
+	}
+
+	// Raw mode applies to the terminal, so it covers /dev/tty as well
+	keys := in
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		keys = tty
+	}
+
+	// Alternate screen, hidden cursor
+	out.WriteString("\x1b[?1049h\x1b[?25l")
+	return &terminal{in: in, out: out, state: state, keys: keys, done: make(chan struct{})}, nil
+}
+
+// close restores the terminal to the state it was in before and stops
+// readKeys
+func (t *terminal) close() {
+	close(t.done)
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	term.Restore(int(t.in.Fd()), t.state)
+	if t.keys != t.in {
+		t.keys.Close()
+	}
+}
+
+// size returns the terminal width and height in cells
+func (t *terminal) size() (int, int) {
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// write sends a finished frame to the terminal
+func (t *terminal) write(frame []byte) {
+	t.out.Write(frame)
+}
+
+// readKeys decodes key presses into ch until reading fails or the
+// terminal is closed. Where stdin is read directly, a read pending at close
+// only returns with the next key press, which is dropped.
+func (t *terminal) readKeys(ch chan<- keyEvent) {
+	defer close(ch)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := t.keys.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		for _, ev := range decodeKeys(buf[:n]) {
+			select {
+			case ch <- ev:
+			case <-t.done:
+				return
+			}
+		}
+	}
+}
+
+// escapeKeys maps the escape sequences of special keys
+var escapeKeys = map[string]Key{
+	"\x1b[A":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1b[C":  KeyRight,
+	"\x1b[D":  KeyLeft,
+	"\x1bOA":  KeyUp,
+	"\x1bOB":  KeyDown,
+	"\x1bOC":  KeyRight,
+	"\x1bOD":  KeyLeft,
+	"\x1b[5~": KeyPageUp,
+	"\x1b[6~": KeyPageDown,
+	"\x1b[H":  KeyHome,
+	"\x1b[F":  KeyEnd,
+	"\x1b[1~": KeyHome,
+	"\x1b[4~": KeyEnd,
+}
+
+// decodeKeys splits raw terminal input into key presses
+func decodeKeys(data []byte) []keyEvent {
+	var events []keyEvent
+	for len(data) > 0 {
+		if data[0] == 0x1b {
+			if len(data) == 1 {
+				events = append(events, keyEvent{key: KeyEscape})
+				break
+			}
+
+			matched := false
+			for seq, key := range escapeKeys {
+				if bytes.HasPrefix(data, []byte(seq)) {
+					events = append(events, keyEvent{key: key})
+					data = data[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// Unknown sequence, drop the rest of the read
+				break
+			}
+			continue
+		}
+
+		switch data[0] {
+		case '\r', '\n':
+			events = append(events, keyEvent{key: KeyEnter})
+			data = data[1:]
+			continue
+		case 0x03:
+			events = append(events, keyEvent{key: KeyInterrupt})
+			data = data[1:]
+			continue
+		}
+
+		r, size := utf8.DecodeRune(data)
+		events = append(events, keyEvent{key: KeyRune, rune: r})
+		data = data[size:]
+	}
+	return events
+}
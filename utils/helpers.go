@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,11 +23,6 @@ func ValidateTags(tags string) (string, error) {
 	return cleaned, nil
 }
 
-// URLEncodeTags properly encodes tags for URL usage
-func URLEncodeTags(tags string) string {
-	return url.QueryEscape(tags)
-}
-
 // ExtractFilenameFromURL extracts filename from URL, handling query parameters
 func ExtractFilenameFromURL(rawURL string) string {
 	filename := filepath.Base(rawURL)
@@ -129,6 +125,30 @@ func FormatFileSize(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// ParseFileSize parses a size such as 500MB, 1.5G or 700MiB into bytes.
+// Units are powers of 1024, like FormatFileSize, and a bare number is bytes.
+func ParseFileSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		for _, unit := range "KMGT" {
+			multiplier *= 1024
+			if byte(unit) == s[i] {
+				break
+			}
+		}
+		s = s[:i]
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500MB or 2G)", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
 // FormatDuration formats duration into human readable format
 func FormatDuration(d time.Duration) string {
 	if d < time.Second {
@@ -217,15 +237,6 @@ func ParsePageFromURL(rawURL string) int {
 	return 0
 }
 
-// BuildContentURL builds the content URL for Rule34
-func BuildContentURL(baseURL, tags string, pid int) string {
-	encodedTags := URLEncodeTags(tags)
-	if pid > 0 {
-		return fmt.Sprintf("%s%s&pid=%d", baseURL, encodedTags, pid)
-	}
-	return fmt.Sprintf("%s%s", baseURL, encodedTags)
-}
-
 // RetryWithBackoff executes a function with exponential backoff
 func RetryWithBackoff(maxRetries int, operation func() error) error {
 	var lastErr error
@@ -276,3 +287,71 @@ func IsSupportedVideoFormat(ext string) bool {
 func IsGifFormat(ext string) bool {
 	return strings.ToLower(ext) == ".gif"
 }
+
+// ExtractPostIDFromURL extracts the post ID from a Rule34 post view URL
+func ExtractPostIDFromURL(rawURL string) string {
+	rawURL = strings.ReplaceAll(rawURL, "&amp;", "&")
+	if questionIndex := strings.Index(rawURL, "?"); questionIndex >= 0 {
+		rawURL = rawURL[questionIndex+1:]
+	}
+
+	values, err := url.ParseQuery(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return values.Get("id")
+}
+
+// SequencePrefix returns a zero-padded sequence number wide enough for total items
+func SequencePrefix(index, total int) string {
+	width := len(fmt.Sprintf("%d", total))
+	if width < 3 {
+		width = 3
+	}
+	return fmt.Sprintf("%0*d", width, index)
+}
+
+// ExtractTagsFromURL extracts the decoded tags parameter from a Rule34 list URL
+func ExtractTagsFromURL(rawURL string) string {
+	rawURL = strings.ReplaceAll(rawURL, "&amp;", "&")
+	if questionIndex := strings.Index(rawURL, "?"); questionIndex >= 0 {
+		rawURL = rawURL[questionIndex+1:]
+	}
+
+	values, err := url.ParseQuery(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return values.Get("tags")
+}
+
+// IsPostID checks if the input is a bare numeric post ID
+func IsPostID(input string) bool {
+	if input == "" {
+		return false
+	}
+	for _, r := range input {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractMD5FromURL returns the MD5 that Rule34 uses as the media filename,
+// or an empty string if the filename isn't an MD5
+func ExtractMD5FromURL(rawURL string) string {
+	filename := ExtractFilenameFromURL(rawURL)
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	// Samples and thumbnails are prefixed, e.g. sample_<md5>
+	if underscoreIndex := strings.LastIndex(name, "_"); underscoreIndex >= 0 {
+		name = name[underscoreIndex+1:]
+	}
+
+	if regexp.MustCompile(`^[0-9a-fA-F]{32}$`).MatchString(name) {
+		return strings.ToLower(name)
+	}
+	return ""
+}